@@ -2,13 +2,20 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"tunnel/pkg/proxyproto"
+	"tunnel/pkg/tunnel"
+
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -41,6 +48,10 @@ var (
 			Foreground(highlightColor).
 			Bold(true)
 
+	errorStyle = lipgloss.NewStyle().
+			Foreground(errorColor).
+			Bold(true)
+
 	logBoxStyle = lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			BorderForeground(subtleColor).
@@ -60,11 +71,14 @@ var (
 type statusMsg string
 type logMsg string
 type errorMsg error
+type fatalMsg string // Status shown when retrying cannot help
+type relayInfoMsg tunnel.HelloReply
 type configReadyMsg struct {
 	serverAddr  string
 	localAddr   string
 	bedrockAddr string
 	gamePort    int
+	bedrockPort int // 0 when Bedrock is disabled
 }
 
 // Application State
@@ -88,9 +102,11 @@ type model struct {
 	gamePort    int
 
 	// Runtime
-	status   string
-	logs     []string
-	quitting bool
+	status      string
+	fatal       bool
+	bedrockPort int // Public Bedrock port, as reported by the relay once connected
+	logs        []string
+	quitting    bool
 
 	// Channel to signal the network loop
 	configChan chan configReadyMsg
@@ -99,7 +115,7 @@ type model struct {
 func initialModel(configChan chan configReadyMsg) model {
 	m := model{
 		state:      stateConfig,
-		inputs:     make([]textinput.Model, 4),
+		inputs:     make([]textinput.Model, 5),
 		status:     "Initializing...",
 		logs:       []string{},
 		configChan: configChan,
@@ -133,6 +149,11 @@ func initialModel(configChan chan configReadyMsg) model {
 			t.SetValue("25565")
 			t.PromptStyle = blurredStyle
 			t.TextStyle = blurredStyle
+		case 4:
+			t.Placeholder = "Public Bedrock Port (e.g. 19132)"
+			t.SetValue("19132")
+			t.PromptStyle = blurredStyle
+			t.TextStyle = blurredStyle
 		}
 
 		m.inputs[i] = t
@@ -171,6 +192,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						port = 25565 // Default fallback
 					}
 					m.gamePort = port
+					m.bedrockPort = 0
+					if m.bedrockAddr != "" {
+						port, err := strconv.Atoi(m.inputs[4].Value())
+						if err != nil {
+							port = 19132 // Default fallback
+						}
+						m.bedrockPort = port
+					}
 
 					// Switch state
 					m.state = stateRunning
@@ -182,6 +211,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							localAddr:   m.localAddr,
 							bedrockAddr: m.bedrockAddr,
 							gamePort:    m.gamePort,
+							bedrockPort: m.bedrockPort,
 						}
 					}()
 
@@ -228,6 +258,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Handle Runtime Messages
 	case statusMsg:
 		m.status = string(msg)
+	case fatalMsg:
+		m.status = string(msg)
+		m.fatal = true
+	case relayInfoMsg:
+		m.gamePort = msg.GamePort
+		m.bedrockPort = msg.BedrockPort
 	case logMsg:
 		m.logs = append(m.logs, string(msg))
 		if len(m.logs) > 10 {
@@ -270,6 +306,7 @@ func (m model) View() string {
 			"Local Java Server Address",
 			"Local Bedrock/Geyser Address (blank to disable)",
 			"Public Game Port (for display)",
+			"Public Bedrock Port",
 		}
 
 		for i := range m.inputs {
@@ -293,7 +330,14 @@ func (m model) View() string {
 		s += fmt.Sprintf("%s %s\n", labelStyle.Render("Relay Server:  "), m.serverAddr)
 		s += fmt.Sprintf("%s %s\n", labelStyle.Render("Local Server:  "), m.localAddr)
 		s += fmt.Sprintf("%s %s:%d\n", labelStyle.Render("Public Address:"), host, m.gamePort)
-		s += fmt.Sprintf("%s %s\n\n", labelStyle.Render("Status:        "), statusStyle.Render(m.status))
+		if m.bedrockAddr != "" && m.bedrockPort > 0 {
+			s += fmt.Sprintf("%s %s:%d (UDP)\n", labelStyle.Render("Bedrock:       "), host, m.bedrockPort)
+		}
+		statusView := statusStyle.Render(m.status)
+		if m.fatal {
+			statusView = errorStyle.Render(m.status)
+		}
+		s += fmt.Sprintf("%s %s\n\n", labelStyle.Render("Status:        "), statusView)
 
 		// Logs
 		var logContent string
@@ -314,7 +358,72 @@ func (m model) View() string {
 	return appStyle.Render(s)
 }
 
+// hostOptions holds settings given on the command line rather than in the TUI
+type hostOptions struct {
+	token     string
+	tlsConfig *tls.Config // nil for a plain TCP control connection
+	priority  int
+	weight    int
+	hostnames []string // Server addresses the relay should route here
+
+	proxyProtocol        int  // PROXY protocol version sent to the local Java server, 0 for none
+	bedrockProxyProtocol bool // Prefix datagrams to the local Bedrock server with PROXY v2
+
+	// How long a Bedrock stream may wait for the local server, 0 to
+	// follow the relay's session idle timeout
+	udpIdleTimeout time.Duration
+}
+
 func main() {
+	token := flag.String("token", "", "Shared secret configured on the relay")
+	tokenFile := flag.String("token-file", "", "File containing the shared secret (alternative to --token)")
+	useTLS := flag.Bool("tls", false, "Connect to the relay over TLS")
+	tlsCA := flag.String("tls-ca", "", "CA certificate to verify the relay against (implies --tls)")
+	tlsFingerprint := flag.String("tls-fingerprint", "", "SHA-256 fingerprint the relay certificate must match (implies --tls)")
+	tlsServerName := flag.String("tls-server-name", "", "Expected relay hostname in its certificate")
+	tlsCert := flag.String("tls-cert", "", "Client certificate identifying this host (implies --tls)")
+	tlsKey := flag.String("tls-key", "", "Private key for --tls-cert")
+	priority := flag.Int("priority", 0, "Standby rank when several hosts connect to one relay (higher is preferred)")
+	weight := flag.Int("weight", 1, "Share of players this host gets when the relay balances by weight")
+	proxyProtocol := flag.String("proxy-protocol", "", "Send a PROXY protocol header (v1 or v2) to the local Java server")
+	bedrockProxyProtocol := flag.Bool("bedrock-proxy-protocol", false, "Prefix datagrams to the local Bedrock server with a PROXY protocol v2 header")
+	udpIdleTimeout := flag.Duration("udp-idle-timeout", 0, "Close Bedrock streams after this long without a packet from the local server (default: the relay's idle timeout)")
+	hostnames := flag.String("hostnames", "", "Comma-separated server addresses routed to this host, e.g. mc.example.com,*.example.com (default all)")
+	flag.Parse()
+
+	secret, err := tunnel.LoadToken(*token, *tokenFile)
+	if err != nil {
+		fmt.Printf("Failed to load token: %v\n", err)
+		os.Exit(1)
+	}
+	opts := hostOptions{token: secret, priority: *priority, weight: *weight, bedrockProxyProtocol: *bedrockProxyProtocol, udpIdleTimeout: *udpIdleTimeout}
+	opts.proxyProtocol, err = proxyproto.ParseVersion(*proxyProtocol)
+	if err != nil {
+		fmt.Printf("Invalid --proxy-protocol: %v\n", err)
+		os.Exit(1)
+	}
+	for _, h := range strings.Split(*hostnames, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			opts.hostnames = append(opts.hostnames, h)
+		}
+	}
+
+	if *useTLS || *tlsCA != "" || *tlsFingerprint != "" || *tlsCert != "" {
+		opts.tlsConfig, err = tunnel.ClientTLSConfig(*tlsCA, *tlsFingerprint, *tlsServerName)
+		if err != nil {
+			fmt.Printf("Failed to set up TLS: %v\n", err)
+			os.Exit(1)
+		}
+		if *tlsCert != "" {
+			cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+			if err != nil {
+				fmt.Printf("Failed to load client certificate: %v\n", err)
+				os.Exit(1)
+			}
+			opts.tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
 	configChan := make(chan configReadyMsg)
 	p := tea.NewProgram(initialModel(configChan))
 
@@ -326,7 +435,23 @@ func main() {
 		// Start loop
 		for {
 			p.Send(statusMsg("Connecting..."))
-			runHost(config.serverAddr, config.localAddr, config.bedrockAddr, p)
+			err := runHost(config, opts, p)
+			// Retrying with the same token or build can never succeed
+			if errors.Is(err, tunnel.ErrAuthFailed) {
+				p.Send(fatalMsg("Authentication failed"))
+				p.Send(logMsg("Relay rejected the token, check --token/--token-file"))
+				return
+			}
+			if errors.Is(err, tunnel.ErrIncompatible) {
+				p.Send(fatalMsg("Incompatible relay"))
+				p.Send(logMsg(err.Error()))
+				return
+			}
+			if errors.Is(err, tunnel.ErrRejected) {
+				p.Send(statusMsg("Rejected (relay busy). Retrying in 5s..."))
+				time.Sleep(5 * time.Second)
+				continue
+			}
 			p.Send(statusMsg("Disconnected. Retrying in 5s..."))
 			time.Sleep(5 * time.Second)
 		}
@@ -337,16 +462,80 @@ func main() {
 	}
 }
 
-func runHost(serverAddr, localAddr, bedrockAddr string, p *tea.Program) {
+func runHost(config configReadyMsg, opts hostOptions, p *tea.Program) error {
+	serverAddr := config.serverAddr
+
 	// 1. Connect to the Relay Server
 	conn, err := net.Dial("tcp", serverAddr)
 	if err != nil {
 		p.Send(errorMsg(err))
-		return
+		return err
 	}
-	p.Send(statusMsg("Connected to Relay"))
 	p.Send(logMsg(fmt.Sprintf("Connected to %s (%s)", serverAddr, conn.RemoteAddr().String())))
 
+	if opts.tlsConfig != nil {
+		tlsConn, err := dialTLS(conn, serverAddr, opts.tlsConfig)
+		if err != nil {
+			conn.Close()
+			p.Send(errorMsg(err))
+			return err
+		}
+		p.Send(logMsg(fmt.Sprintf("TLS established (%s)", tls.VersionName(tlsConn.ConnectionState().Version))))
+		conn = tlsConn
+	}
+
+	// Prove we know the shared secret before the relay accepts the session
+	if err := tunnel.ClientAuth(conn, opts.token); err != nil {
+		conn.Close()
+		if !errors.Is(err, tunnel.ErrAuthFailed) {
+			p.Send(errorMsg(err))
+		}
+		return err
+	}
+
+	// Agree on protocol version and features before multiplexing
+	reply, err := tunnel.ClientHello(conn, tunnel.Hello{
+		ProtocolVersion: tunnel.ProtocolVersion,
+		ClientVersion:   tunnel.Version,
+		Features:        tunnel.Features,
+		GamePort:        config.gamePort,
+		BedrockPort:     config.bedrockPort,
+		Priority:        opts.priority,
+		Weight:          opts.weight,
+		Hostnames:       opts.hostnames,
+	})
+	if err != nil {
+		conn.Close()
+		if errors.Is(err, tunnel.ErrRejected) {
+			p.Send(logMsg(err.Error()))
+		} else if !errors.Is(err, tunnel.ErrIncompatible) {
+			p.Send(errorMsg(err))
+		}
+		return err
+	}
+	p.Send(relayInfoMsg(reply))
+	p.Send(logMsg(fmt.Sprintf("Relay %s (protocol v%d)", reply.ServerVersion, reply.ProtocolVersion)))
+	if config.gamePort != reply.GamePort {
+		p.Send(logMsg(fmt.Sprintf("Relay serves game port %d, not %d", reply.GamePort, config.gamePort)))
+	}
+	if config.bedrockPort != 0 && config.bedrockPort != reply.BedrockPort {
+		if reply.BedrockPort == 0 {
+			p.Send(logMsg("Relay does not serve Bedrock players (no --bedrock-port)"))
+		} else {
+			p.Send(logMsg(fmt.Sprintf("Relay serves Bedrock port %d, not %d", reply.BedrockPort, config.bedrockPort)))
+		}
+	}
+	if len(opts.hostnames) > 0 {
+		p.Send(logMsg("Serving " + strings.Join(opts.hostnames, ", ")))
+	}
+	if opts.udpIdleTimeout == 0 {
+		opts.udpIdleTimeout = time.Duration(reply.UDPIdleTimeout) * time.Second
+	}
+	if opts.udpIdleTimeout == 0 {
+		opts.udpIdleTimeout = 30 * time.Second
+	}
+	p.Send(statusMsg("Connected to Relay"))
+
 	// 2. Setup Yamux Client
 	// Capture yamux logs to the UI
 	r, w := io.Pipe()
@@ -359,15 +548,15 @@ func runHost(serverAddr, localAddr, bedrockAddr string, p *tea.Program) {
 		}
 	}()
 
-	config := yamux.DefaultConfig()
-	config.KeepAliveInterval = 10 * time.Second
-	config.LogOutput = w
+	yamuxConfig := yamux.DefaultConfig()
+	yamuxConfig.KeepAliveInterval = 10 * time.Second
+	yamuxConfig.LogOutput = w
 
-	session, err := yamux.Client(conn, config)
+	session, err := yamux.Client(conn, yamuxConfig)
 	if err != nil {
 		p.Send(errorMsg(err))
 		conn.Close()
-		return
+		return err
 	}
 
 	// 3. Accept streams from the Relay
@@ -375,14 +564,34 @@ func runHost(serverAddr, localAddr, bedrockAddr string, p *tea.Program) {
 		stream, err := session.Accept()
 		if err != nil {
 			p.Send(errorMsg(err))
-			return
+			return err
+		}
+
+		go handleStream(stream, config.localAddr, config.bedrockAddr, opts, p)
+	}
+}
+
+// dialTLS runs the TLS handshake on an established control connection
+func dialTLS(conn net.Conn, serverAddr string, config *tls.Config) (*tls.Conn, error) {
+	config = config.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(serverAddr)
+		if err != nil {
+			host = serverAddr
 		}
+		config.ServerName = host
+	}
 
-		go handleStream(stream, localAddr, bedrockAddr, p)
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(tunnel.HandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
 	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
 }
 
-func handleStream(stream net.Conn, localAddr, bedrockAddr string, p *tea.Program) {
+func handleStream(stream net.Conn, localAddr, bedrockAddr string, opts hostOptions, p *tea.Program) {
 	defer stream.Close()
 
 	// 4. Read Player IP Header
@@ -399,21 +608,23 @@ func handleStream(stream net.Conn, localAddr, bedrockAddr string, p *tea.Program
 	}
 	header = strings.TrimSpace(header)
 
-	// Parse protocol and player IP
-	var protocol, playerIP string
-	if strings.HasPrefix(header, "tcp:") {
-		protocol = "tcp"
-		playerIP = strings.TrimPrefix(header, "tcp:")
-	} else if strings.HasPrefix(header, "udp:") {
-		protocol = "udp"
-		playerIP = strings.TrimPrefix(header, "udp:")
-	} else {
-		// Backwards compatibility: assume TCP if no prefix
-		protocol = "tcp"
-		playerIP = header
+	// The relay refreshing its cached Bedrock server list entry
+	if header == "ping:" {
+		if bedrockAddr != "" {
+			relayBedrockPing(stream, bufReader, bedrockAddr)
+		}
+		return
+	}
+
+	// Parse protocol, player IP and, from newer relays, the player's name
+	player := tunnel.ParseStreamHeader(header)
+	protocol, playerIP := player.Protocol, player.Addr
+	label := playerIP
+	if player.Name != "" {
+		label = fmt.Sprintf("%s (%s)", player.Name, playerIP)
 	}
 
-	p.Send(logMsg(fmt.Sprintf("[%s] Player connected: %s", strings.ToUpper(protocol), playerIP)))
+	p.Send(logMsg(fmt.Sprintf("[%s] Player connected: %s", strings.ToUpper(protocol), label)))
 
 	if protocol == "udp" {
 		// Handle UDP/Bedrock traffic
@@ -421,7 +632,7 @@ func handleStream(stream net.Conn, localAddr, bedrockAddr string, p *tea.Program
 			p.Send(errorMsg(fmt.Errorf("Bedrock player connected but no local Bedrock address configured")))
 			return
 		}
-		handleUDPStream(stream, bufReader, bedrockAddr, playerIP, p)
+		handleUDPStream(stream, bufReader, bedrockAddr, playerIP, opts, p)
 		return
 	}
 
@@ -433,6 +644,22 @@ func handleStream(stream net.Conn, localAddr, bedrockAddr string, p *tea.Program
 	}
 	defer localConn.Close()
 
+	// Tell the local server who the player really is
+	if opts.proxyProtocol != 0 {
+		var src net.Addr
+		if addr, err := net.ResolveTCPAddr("tcp", playerIP); err == nil {
+			src = addr
+		}
+		header, err := proxyproto.Header(opts.proxyProtocol, src, localConn.RemoteAddr())
+		if err == nil {
+			_, err = localConn.Write(header)
+		}
+		if err != nil {
+			p.Send(errorMsg(fmt.Errorf("failed to send PROXY header: %v", err)))
+			return
+		}
+	}
+
 	// Bidirectional copy
 	done := make(chan struct{})
 
@@ -450,11 +677,44 @@ func handleStream(stream net.Conn, localAddr, bedrockAddr string, p *tea.Program
 	}()
 
 	<-done
-	p.Send(logMsg(fmt.Sprintf("[TCP] Player disconnected: %s", playerIP)))
+	p.Send(logMsg(fmt.Sprintf("[TCP] Player disconnected: %s", label)))
+}
+
+// relayBedrockPing forwards one length-prefixed RakNet ping to the local
+// Bedrock server and returns its pong the same way
+func relayBedrockPing(stream net.Conn, bufReader *bufio.Reader, bedrockAddr string) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(bufReader, lenBuf); err != nil {
+		return
+	}
+	ping := make([]byte, int(lenBuf[0])<<8|int(lenBuf[1]))
+	if _, err := io.ReadFull(bufReader, ping); err != nil {
+		return
+	}
+
+	localConn, err := net.Dial("udp", bedrockAddr)
+	if err != nil {
+		return
+	}
+	defer localConn.Close()
+
+	localConn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := localConn.Write(ping); err != nil {
+		return
+	}
+	buffer := make([]byte, 65535)
+	n, err := localConn.Read(buffer)
+	if err != nil {
+		return
+	}
+
+	lenBuf[0] = byte(n >> 8)
+	lenBuf[1] = byte(n & 0xFF)
+	stream.Write(append(lenBuf, buffer[:n]...))
 }
 
 // handleUDPStream handles Bedrock Edition UDP traffic over the yamux stream
-func handleUDPStream(stream net.Conn, bufReader *bufio.Reader, bedrockAddr string, playerIP string, p *tea.Program) {
+func handleUDPStream(stream net.Conn, bufReader *bufio.Reader, bedrockAddr string, playerIP string, opts hostOptions, p *tea.Program) {
 	// Resolve UDP address
 	udpAddr, err := net.ResolveUDPAddr("udp", bedrockAddr)
 	if err != nil {
@@ -470,6 +730,16 @@ func handleUDPStream(stream net.Conn, bufReader *bufio.Reader, bedrockAddr strin
 	}
 	defer localConn.Close()
 
+	// Geyser reads the player address from a PROXY v2 header on each datagram
+	var proxyHeader []byte
+	if opts.bedrockProxyProtocol {
+		var src net.Addr
+		if addr, err := net.ResolveUDPAddr("udp", playerIP); err == nil {
+			src = addr
+		}
+		proxyHeader, _ = proxyproto.Header(2, src, localConn.RemoteAddr())
+	}
+
 	done := make(chan struct{})
 
 	// Stream -> Local UDP (read length-prefixed packets from stream)
@@ -488,9 +758,10 @@ func handleUDPStream(stream net.Conn, bufReader *bufio.Reader, bedrockAddr strin
 				return
 			}
 
-			// Read packet data
-			data := make([]byte, pktLen)
-			_, err = io.ReadFull(bufReader, data)
+			// Read packet data after room for the PROXY header
+			data := make([]byte, len(proxyHeader)+pktLen)
+			copy(data, proxyHeader)
+			_, err = io.ReadFull(bufReader, data[len(proxyHeader):])
 			if err != nil {
 				return
 			}
@@ -505,7 +776,7 @@ func handleUDPStream(stream net.Conn, bufReader *bufio.Reader, bedrockAddr strin
 		defer func() { done <- struct{}{} }()
 		buffer := make([]byte, 65535)
 		for {
-			localConn.SetReadDeadline(time.Now().Add(30 * time.Second))
+			localConn.SetReadDeadline(time.Now().Add(opts.udpIdleTimeout))
 			n, err := localConn.Read(buffer)
 			if err != nil {
 				return
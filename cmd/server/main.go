@@ -3,12 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"tunnel/pkg/daemon"
 	"tunnel/pkg/relay"
+	"tunnel/pkg/tunnel"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -19,28 +23,137 @@ func main() {
 	gamePort := flag.Int("game-port", 25565, "Game port for Java Edition players (TCP)")
 	bedrockPort := flag.Int("bedrock-port", 0, "Game port for Bedrock Edition players via Geyser (UDP, 0 to disable)")
 	apiPort := flag.Int("api-port", 6060, "API port for status/logs")
+	apiBind := flag.String("api-bind", relay.DefaultAPIBind, "Address the API listens on (0.0.0.0 for all interfaces)")
+	apiToken := flag.String("api-token", "", "Admin bearer token for the API, also used by monitor (default $"+apiTokenEnv+")")
+	apiReadToken := flag.String("api-read-token", "", "Read-only bearer token for the API (default $"+apiReadTokenEnv+")")
+	apiTokensFile := flag.String("api-tokens-file", daemon.DefaultAPITokensFile(), "JSON file of API bearer tokens and their scopes")
+	apiAllowedOrigins := flag.String("api-allowed-origins", "", "Comma-separated browser origins allowed to open /events WebSockets besides the API's own")
+	apiTLS := flag.Bool("api-tls", false, "Serve the API over HTTPS")
+	apiTLSCert := flag.String("api-tls-cert", "", "TLS certificate for the API (default: --tls-cert)")
+	apiTLSKey := flag.String("api-tls-key", "", "TLS private key for the API (default: --tls-key)")
+	token := flag.String("token", "", "Shared secret hosts must prove they know (default $"+tokenEnv+")")
+	tokenFile := flag.String("token-file", "", "File containing the shared secret (alternative to --token)")
+	useTLS := flag.Bool("tls", false, "Encrypt the control channel with TLS")
+	tlsCert := flag.String("tls-cert", daemon.DefaultTLSCertFile(), "TLS certificate for the control channel")
+	tlsKey := flag.String("tls-key", daemon.DefaultTLSKeyFile(), "TLS private key for the control channel")
+	requireClientCert := flag.Bool("require-client-cert", false, "Require hosts to present a certificate from the host CA (implies --tls)")
+	caDir := flag.String("ca-dir", daemon.DefaultCADir(), "Directory of the host certificate authority")
+	hostPolicy := flag.String("host-policy", relay.HostPolicyStandby, "When a second host connects: replace, reject, standby or balance")
+	balance := flag.String("balance", relay.BalanceRoundRobin, "Host choice under --host-policy=balance: round-robin, least-connections or weighted")
+	unknownHostMessage := flag.String("unknown-host-message", relay.DefaultUnknownHostMessage, "Disconnect message for server addresses no host serves")
+	motdFile := flag.String("motd-file", "", "JSON file with the MOTD, favicon and kick message shown while no host is connected")
+	statusCacheTTL := flag.Duration("status-cache-ttl", 5*time.Second, "How long to reuse a host's server list response (0 to forward every ping)")
+	bedrockIdleTimeout := flag.Duration("bedrock-idle-timeout", relay.DefaultBedrockIdleTimeout, "Close Bedrock sessions after this long without a packet from the player")
+	bedrockMaxSessions := flag.Int("bedrock-max-sessions", 1000, "Maximum concurrent Bedrock sessions (0 for no limit)")
+	limits := relay.DefaultLimits()
+	flag.Float64Var(&limits.IPRate, "rate-limit", limits.IPRate, "New player connections per second allowed from one IP (0 to disable)")
+	flag.IntVar(&limits.IPBurst, "rate-burst", limits.IPBurst, "Connections one IP may open at once before --rate-limit applies")
+	flag.Float64Var(&limits.SubnetRate, "subnet-rate-limit", limits.SubnetRate, "New player connections per second from one /24 or /64 (0 to disable)")
+	flag.IntVar(&limits.SubnetBurst, "subnet-rate-burst", limits.SubnetBurst, "Burst allowance for --subnet-rate-limit")
+	flag.IntVar(&limits.MaxPerIP, "max-conns-per-ip", limits.MaxPerIP, "Concurrent player connections from one IP (0 for no limit)")
+	flag.IntVar(&limits.MaxTotal, "max-conns", limits.MaxTotal, "Concurrent player connections overall (0 for no limit)")
+	aclFile := flag.String("acl-file", daemon.DefaultACLFile(), "JSON file of allowed, denied and banned player IPs/CIDRs (reloaded on SIGHUP)")
+	logLevel := flag.String("log-level", "info", "Lowest log level recorded: debug, info, warn or error")
+	logFormat := flag.String("log-format", relay.LogFormatText, "Log file format: text or json")
+	webhooksFile := flag.String("webhooks-file", daemon.DefaultWebhooksFile(), "JSON file of URLs that receive relay events")
+	tunnelDownAfter := flag.Duration("tunnel-down-after", 60*time.Second, "Send a tunnel_down event after this long without a host (0 to disable)")
+	logHistory := flag.Int("log-history", relay.DefaultLogHistory, "Recent log events kept for /logs replay and /logs/history")
 	isDaemon := flag.Bool("daemon", false, "Run as daemon (internal use)")
 
 	flag.Parse()
 
+	switch *hostPolicy {
+	case relay.HostPolicyReplace, relay.HostPolicyReject, relay.HostPolicyStandby, relay.HostPolicyBalance:
+	default:
+		fmt.Printf("Invalid --host-policy %q (want replace, reject, standby or balance)\n", *hostPolicy)
+		os.Exit(1)
+	}
+	switch *balance {
+	case relay.BalanceRoundRobin, relay.BalanceLeastConnections, relay.BalanceWeighted:
+	default:
+		fmt.Printf("Invalid --balance %q (want round-robin, least-connections or weighted)\n", *balance)
+		os.Exit(1)
+	}
+
+	level, err := relay.ParseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Printf("Invalid --log-level: %v\n", err)
+		os.Exit(1)
+	}
+	switch *logFormat {
+	case relay.LogFormatText, relay.LogFormatJSON:
+	default:
+		fmt.Printf("Invalid --log-format %q (want text or json)\n", *logFormat)
+		os.Exit(1)
+	}
+
 	pidFile := daemon.DefaultPidFile()
 	logFile := daemon.DefaultLogFile()
 
+	cfg := relay.Config{
+		ControlPort: *controlPort,
+		GamePort:    *gamePort,
+		BedrockPort: *bedrockPort,
+		HostPolicy:  *hostPolicy,
+
+		BalanceStrategy:    *balance,
+		UnknownHostMessage: *unknownHostMessage,
+		StatusCacheTTL:     *statusCacheTTL,
+		BedrockIdleTimeout: *bedrockIdleTimeout,
+		BedrockMaxSessions: *bedrockMaxSessions,
+		Limits:             limits,
+		LogLevel:           level,
+		LogFormat:          *logFormat,
+		LogHistory:         *logHistory,
+		TunnelDownAfter:    *tunnelDownAfter,
+	}
+	if *useTLS || *requireClientCert {
+		cfg.TLSCertFile = *tlsCert
+		cfg.TLSKeyFile = *tlsKey
+	}
+	if *requireClientCert {
+		cfg.ClientCADir = *caDir
+	}
+	cfg.API.Bind = *apiBind
+	for _, o := range strings.Split(*apiAllowedOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			cfg.API.AllowedOrigins = append(cfg.API.AllowedOrigins, o)
+		}
+	}
+	if *apiTLS {
+		cfg.API.TLSCertFile, cfg.API.TLSKeyFile = *apiTLSCert, *apiTLSKey
+		if cfg.API.TLSCertFile == "" {
+			cfg.API.TLSCertFile = *tlsCert
+		}
+		if cfg.API.TLSKeyFile == "" {
+			cfg.API.TLSKeyFile = *tlsKey
+		}
+	}
+
 	// Check for subcommand
 	args := flag.Args()
 	if len(args) > 0 {
 		switch args[0] {
 		case "start":
-			handleStart(pidFile, logFile, *controlPort, *gamePort, *bedrockPort, *apiPort)
+			cfg.Token = mustLoadToken(*token, *tokenFile)
+			cfg.OfflineStatus = mustLoadOfflineStatus(*motdFile)
+			cfg.ACL = mustLoadACL(*aclFile)
+			cfg.API.Tokens = mustLoadAPITokens(*apiToken, *apiReadToken, *apiTokensFile)
+			cfg.Webhooks = mustLoadWebhooks(*webhooksFile)
+			handleStart(pidFile, logFile, cfg, *apiPort)
 			return
 		case "stop":
 			handleStop(pidFile)
 			return
 		case "status":
-			handleStatus(pidFile, *apiPort)
+			handleStatus(pidFile, cfg.API, *apiPort)
 			return
 		case "monitor":
-			runMonitor(*apiPort)
+			cfg.API.Tokens = mustLoadAPITokens(*apiToken, *apiReadToken, *apiTokensFile)
+			runMonitor(cfg.API, *apiPort)
+			return
+		case "certs":
+			handleCerts(*caDir, args[1:])
 			return
 		case "help":
 			printHelp()
@@ -54,7 +167,12 @@ func main() {
 
 	// If running as daemon (forked process)
 	if *isDaemon {
-		runDaemon(pidFile, *controlPort, *gamePort, *bedrockPort, *apiPort)
+		cfg.Token = mustLoadToken(*token, *tokenFile)
+		cfg.OfflineStatus = mustLoadOfflineStatus(*motdFile)
+		cfg.ACL = mustLoadACL(*aclFile)
+		cfg.API.Tokens = mustLoadAPITokens(*apiToken, *apiReadToken, *apiTokensFile)
+		cfg.Webhooks = mustLoadWebhooks(*webhooksFile)
+		runDaemon(pidFile, cfg, *apiPort)
 		return
 	}
 
@@ -70,38 +188,302 @@ func printHelp() {
 	fmt.Println("  tunnel-server stop     Stop the relay server")
 	fmt.Println("  tunnel-server status   Show server status")
 	fmt.Println("  tunnel-server monitor  Open the TUI monitor (attach to running server)")
+	fmt.Println("  tunnel-server certs    Manage host client certificates (issue|revoke|list)")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  --control-port int   Control port for Host connection (default 8080)")
 	fmt.Println("  --game-port int      Game port for Java Edition players (default 25565)")
 	fmt.Println("  --bedrock-port int   Game port for Bedrock Edition via Geyser (default 0, disabled)")
 	fmt.Println("  --api-port int       API port for status/logs (default 6060)")
+	fmt.Println("  --api-bind string    Address the API listens on (default 127.0.0.1)")
+	fmt.Println("  --token string       Shared secret hosts must prove they know (default $" + tokenEnv + ")")
+	fmt.Println("  --token-file string  File containing the shared secret")
+	fmt.Println("  --tls                Encrypt the control channel with TLS")
+	fmt.Println("  --tls-cert string    TLS certificate (default ~/.tunnel-relay-cert.pem)")
+	fmt.Println("  --tls-key string     TLS private key (default ~/.tunnel-relay-key.pem)")
+	fmt.Println("  --require-client-cert  Require a host certificate from the host CA")
+	fmt.Println("  --ca-dir string      Host CA directory (default ~/.tunnel-relay-ca)")
+	fmt.Println("  --host-policy string When a second host connects: replace, reject, standby or balance (default standby)")
+	fmt.Println("  --balance string     Host choice with balance: round-robin, least-connections or weighted")
+	fmt.Println("  --unknown-host-message string")
+	fmt.Println("                       Disconnect message for server addresses no host serves")
+	fmt.Println("  --motd-file string   JSON file with the MOTD shown while no host is connected")
+	fmt.Println("  --status-cache-ttl duration")
+	fmt.Println("                       How long to reuse a host's server list response (default 5s, 0 to disable)")
+	fmt.Println()
+	fmt.Println("Connection Limits (Java and Bedrock players):")
+	fmt.Println("  --rate-limit float   New connections per second from one IP (default 2)")
+	fmt.Println("  --rate-burst int     Burst allowance for --rate-limit (default 10)")
+	fmt.Println("  --subnet-rate-limit float")
+	fmt.Println("                       New connections per second from one /24 or /64 (default 10)")
+	fmt.Println("  --subnet-rate-burst int")
+	fmt.Println("                       Burst allowance for --subnet-rate-limit (default 50)")
+	fmt.Println("  --max-conns-per-ip int")
+	fmt.Println("                       Concurrent connections from one IP (default 10)")
+	fmt.Println("  --max-conns int      Concurrent connections overall (default 1000)")
+	fmt.Println("  --acl-file string    Allowed, denied and banned IPs/CIDRs, reloaded on SIGHUP")
+	fmt.Println("                       (default ~/.tunnel-relay-acl.json, edit via the /acl API)")
+	fmt.Println()
+	fmt.Println("Webhooks:")
+	fmt.Println("  --webhooks-file string")
+	fmt.Println("                       URLs that receive player, host and tunnel events")
+	fmt.Println("                       (default ~/.tunnel-relay-webhooks.json)")
+	fmt.Println("  --tunnel-down-after duration")
+	fmt.Println("                       Send tunnel_down after this long without a host (default 1m0s, 0 to disable)")
+	fmt.Println()
+	fmt.Println("API Access:")
+	fmt.Println("  --api-token string   Admin bearer token, also used by monitor (default $" + apiTokenEnv + ")")
+	fmt.Println("  --api-read-token string")
+	fmt.Println("                       Read-only bearer token, GET requests only (default $" + apiReadTokenEnv + ")")
+	fmt.Println("  --api-tokens-file string")
+	fmt.Println("                       Named tokens with read or admin scope (default ~/.tunnel-relay-api-tokens.json)")
+	fmt.Println("  --api-allowed-origins string")
+	fmt.Println("                       Browser origins allowed to open /events WebSockets, comma-separated")
+	fmt.Println("  --api-tls            Serve the API over HTTPS")
+	fmt.Println("  --api-tls-cert string, --api-tls-key string")
+	fmt.Println("                       API certificate and key (default --tls-cert and --tls-key)")
+	fmt.Println("  Without any token the API is open to everyone who can reach --api-bind.")
+	fmt.Println()
+	fmt.Println("Logging:")
+	fmt.Println("  --log-level string   Lowest level recorded: debug, info, warn or error (default info)")
+	fmt.Println("  --log-format string  Log file format: text or json (default text)")
+	fmt.Println("  --log-history int    Recent events kept for replay and /logs/history (default 1000)")
 	fmt.Println()
 	fmt.Println("Geyser Support:")
 	fmt.Println("  To enable Bedrock Edition support via Geyser, use --bedrock-port=19132")
 	fmt.Println("  This opens a UDP port for Bedrock players to connect through.")
+	fmt.Println("  --bedrock-idle-timeout duration")
+	fmt.Println("                       Close silent Bedrock sessions after this long (default 30s)")
+	fmt.Println("  --bedrock-max-sessions int")
+	fmt.Println("                       Maximum concurrent Bedrock sessions (default 1000, 0 for no limit)")
+	fmt.Println()
+	fmt.Println("TLS:")
+	fmt.Println("  With --tls, a self-signed certificate is generated on first start if")
+	fmt.Println("  none exists. Pin it on the host with --tls-fingerprint.")
+	fmt.Println()
+	fmt.Println("Host Certificates:")
+	fmt.Println("  tunnel-server certs issue <name> [dir]  Issue a certificate for a host")
+	fmt.Println("  tunnel-server certs revoke <name|serial> Revoke a host certificate")
+	fmt.Println("  tunnel-server certs list                 List issued certificates")
+}
+
+// mustLoadToken resolves --token/--token-file or exits
+func mustLoadToken(token, tokenFile string) string {
+	if token == "" && tokenFile == "" {
+		token = os.Getenv(tokenEnv)
+	}
+	secret, err := tunnel.LoadToken(token, tokenFile)
+	if err != nil {
+		fmt.Printf("Failed to load token: %v\n", err)
+		os.Exit(1)
+	}
+	return secret
+}
+
+func mustLoadOfflineStatus(motdFile string) relay.OfflineStatus {
+	if motdFile == "" {
+		return relay.DefaultOfflineStatus()
+	}
+	status, err := relay.LoadOfflineStatus(motdFile)
+	if err != nil {
+		fmt.Printf("Failed to load MOTD file: %v\n", err)
+		os.Exit(1)
+	}
+	return status
+}
+
+func mustLoadACL(aclFile string) *relay.ACL {
+	acl, err := relay.LoadACL(aclFile)
+	if err != nil {
+		fmt.Printf("Failed to load ACL file: %v\n", err)
+		os.Exit(1)
+	}
+	return acl
+}
+
+func mustLoadWebhooks(webhooksFile string) []relay.Webhook {
+	webhooks, err := relay.LoadWebhooks(webhooksFile)
+	if err != nil {
+		fmt.Printf("Failed to load webhooks file: %v\n", err)
+		os.Exit(1)
+	}
+	return webhooks
+}
+
+// Environment variables holding secrets whose flags are not given. start
+// also passes the secret flags to the daemon this way, since its command
+// line is visible to every local user.
+const (
+	tokenEnv        = "TUNNEL_TOKEN"
+	apiTokenEnv     = "TUNNEL_API_TOKEN"
+	apiReadTokenEnv = "TUNNEL_API_READ_TOKEN"
+)
+
+// secretFlags maps the flags start must keep out of the daemon's command
+// line to the environment variables that replace them
+var secretFlags = map[string]string{
+	"token":          tokenEnv,
+	"api-token":      apiTokenEnv,
+	"api-read-token": apiReadTokenEnv,
 }
 
-func handleStart(pidFile, logFile string, controlPort, gamePort, bedrockPort, apiPort int) {
-	// Build args to pass to daemon
-	args := []string{
-		fmt.Sprintf("--control-port=%d", controlPort),
-		fmt.Sprintf("--game-port=%d", gamePort),
-		fmt.Sprintf("--bedrock-port=%d", bedrockPort),
-		fmt.Sprintf("--api-port=%d", apiPort),
+// mustLoadAPITokens combines --api-token (or $TUNNEL_API_TOKEN) and
+// --api-read-token (or $TUNNEL_API_READ_TOKEN) with the tokens file, or
+// exits
+func mustLoadAPITokens(token, readToken, tokensFile string) []relay.APIToken {
+	if token == "" {
+		token = os.Getenv(apiTokenEnv)
+	}
+	if readToken == "" {
+		readToken = os.Getenv(apiReadTokenEnv)
 	}
 
-	if err := daemon.Start(pidFile, logFile, args); err != nil {
+	var tokens []relay.APIToken
+	if token != "" {
+		tokens = append(tokens, relay.APIToken{Name: "api-token", Token: token, Scope: relay.APIScopeAdmin})
+	}
+	if readToken != "" {
+		tokens = append(tokens, relay.APIToken{Name: "api-read-token", Token: readToken, Scope: relay.APIScopeRead})
+	}
+
+	fileTokens, err := relay.LoadAPITokens(tokensFile)
+	if err != nil {
+		fmt.Printf("Failed to load API tokens: %v\n", err)
+		os.Exit(1)
+	}
+	return append(tokens, fileTokens...)
+}
+
+func handleStart(pidFile, logFile string, cfg relay.Config, apiPort int) {
+	if cfg.TLSCertFile != "" {
+		if err := ensureCertificate(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			fmt.Printf("Failed to prepare TLS certificate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.API.TLSCertFile != "" && cfg.API.TLSCertFile != cfg.TLSCertFile {
+		if err := ensureCertificate(cfg.API.TLSCertFile, cfg.API.TLSKeyFile); err != nil {
+			fmt.Printf("Failed to prepare API TLS certificate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Forward every flag given on the command line to the daemon, secrets
+	// through its environment
+	var args, env []string
+	flag.Visit(func(f *flag.Flag) {
+		if name, ok := secretFlags[f.Name]; ok {
+			env = append(env, name+"="+f.Value.String())
+			return
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+
+	if err := daemon.Start(pidFile, logFile, args, env); err != nil {
 		fmt.Printf("Failed to start daemon: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("API available at http://localhost:%d\n", apiPort)
-	if bedrockPort > 0 {
-		fmt.Printf("Bedrock/Geyser port: %d (UDP)\n", bedrockPort)
+	fmt.Printf("API available at %s\n", apiURL(cfg.API, apiPort))
+	if len(cfg.API.Tokens) == 0 {
+		if ip := net.ParseIP(cfg.API.Bind); ip == nil || !ip.IsLoopback() {
+			fmt.Println("WARNING: No API token set, anyone who can reach the API can kick players and edit the ACL")
+		}
+	}
+	if cfg.BedrockPort > 0 {
+		fmt.Printf("Bedrock/Geyser port: %d (UDP)\n", cfg.BedrockPort)
+	}
+	if cfg.Token == "" {
+		fmt.Println("WARNING: No --token set, any host can take over the tunnel")
 	}
 	fmt.Println("Use 'tunnel-server monitor' to view status")
 }
 
+// ensureCertificate generates a self-signed certificate on first start and
+// prints the fingerprint hosts should pin
+func ensureCertificate(certFile, keyFile string) error {
+	if _, err := os.Stat(certFile); os.IsNotExist(err) {
+		if err := tunnel.GenerateSelfSigned(certFile, keyFile); err != nil {
+			return err
+		}
+		fmt.Printf("Generated self-signed certificate: %s\n", certFile)
+	}
+
+	fingerprint, err := tunnel.FileFingerprint(certFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("TLS fingerprint: %s\n", fingerprint)
+	return nil
+}
+
+func handleCerts(caDir string, args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: tunnel-server certs issue|revoke|list")
+		os.Exit(1)
+	}
+
+	ca, err := relay.OpenCA(caDir)
+	if err != nil {
+		fmt.Printf("Failed to open host CA: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "issue":
+		if len(args) < 2 {
+			fmt.Println("Usage: tunnel-server certs issue <name> [dir]")
+			os.Exit(1)
+		}
+		outDir := "."
+		if len(args) > 2 {
+			outDir = args[2]
+		}
+		certFile, keyFile, err := ca.Issue(args[1], outDir)
+		if err != nil {
+			fmt.Printf("Failed to issue certificate: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Issued certificate for %s\n", args[1])
+		fmt.Printf("  Certificate: %s\n", certFile)
+		fmt.Printf("  Key:         %s\n", keyFile)
+		fmt.Printf("Use on the host: tunnel-client --tls-cert=%s --tls-key=%s\n", certFile, keyFile)
+	case "revoke":
+		if len(args) < 2 {
+			fmt.Println("Usage: tunnel-server certs revoke <name|serial>")
+			os.Exit(1)
+		}
+		revoked, err := ca.Revoke(args[1])
+		if err != nil {
+			fmt.Printf("Failed to revoke certificate: %v\n", err)
+			os.Exit(1)
+		}
+		for _, c := range revoked {
+			fmt.Printf("Revoked %s (serial %s)\n", c.Name, c.Serial)
+		}
+	case "list":
+		certs, err := ca.List()
+		if err != nil {
+			fmt.Printf("Failed to list certificates: %v\n", err)
+			os.Exit(1)
+		}
+		if len(certs) == 0 {
+			fmt.Println("No certificates issued")
+			return
+		}
+		fmt.Printf("%-20s %-34s %-12s %s\n", "NAME", "SERIAL", "EXPIRES", "STATUS")
+		for _, c := range certs {
+			status := "valid"
+			if c.Revoked {
+				status = "revoked " + c.RevokedAt.Format("2006-01-02")
+			}
+			fmt.Printf("%-20s %-34s %-12s %s\n", c.Name, c.Serial, c.NotAfter.Format("2006-01-02"), status)
+		}
+	default:
+		fmt.Printf("Unknown certs command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
 func handleStop(pidFile string) {
 	if err := daemon.Stop(pidFile); err != nil {
 		fmt.Printf("Failed to stop daemon: %v\n", err)
@@ -109,59 +491,72 @@ func handleStop(pidFile string) {
 	}
 }
 
-func handleStatus(pidFile string, apiPort int) {
+// apiURL is the base URL local tools use to reach the API
+func apiURL(cfg relay.APIConfig, apiPort int) string {
+	if cfg.TLSCertFile != "" {
+		return "https://" + relay.APIHost(cfg.Bind, apiPort)
+	}
+	return "http://" + relay.APIHost(cfg.Bind, apiPort)
+}
+
+func handleStatus(pidFile string, api relay.APIConfig, apiPort int) {
 	running, pid := daemon.Status(pidFile)
 	if running {
 		fmt.Printf("Tunnel Relay Server is running (PID %d)\n", pid)
-		fmt.Printf("API: http://localhost:%d/status\n", apiPort)
+		fmt.Printf("API: %s/status\n", apiURL(api, apiPort))
 	} else {
 		fmt.Println("Tunnel Relay Server is not running")
 	}
 }
 
-func runMonitor(apiPort int) {
-	p := tea.NewProgram(initialModel(apiPort))
+func runMonitor(api relay.APIConfig, apiPort int) {
+	client, err := newAPIClient(api, apiPort)
+	if err != nil {
+		fmt.Printf("Failed to set up API client: %v\n", err)
+		os.Exit(1)
+	}
+	p := tea.NewProgram(initialModel(client))
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running monitor: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func runDaemon(pidFile string, controlPort, gamePort, bedrockPort, apiPort int) {
+func runDaemon(pidFile string, cfg relay.Config, apiPort int) {
 	// Write PID file
 	if err := daemon.WritePid(pidFile); err != nil {
 		fmt.Printf("Failed to write PID file: %v\n", err)
 		os.Exit(1)
 	}
 
+	// The daemon's stdout is the log file
+	cfg.LogOutput = os.Stdout
+	r := relay.New(cfg)
+
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
 
 	go func() {
 		<-sigChan
-		fmt.Println("Shutting down...")
+		r.Log("Shutting down...")
 		daemon.RemovePid(pidFile)
 		os.Exit(0)
 	}()
 
-	fmt.Println("Starting Tunnel Relay Server (daemon mode)...")
-	fmt.Printf("Control Port:  %d\n", controlPort)
-	fmt.Printf("Game Port:     %d (Java/TCP)\n", gamePort)
-	if bedrockPort > 0 {
-		fmt.Printf("Bedrock Port:  %d (Geyser/UDP)\n", bedrockPort)
-	}
-	fmt.Printf("API Port:      %d\n", apiPort)
-
-	cfg := relay.Config{
-		ControlPort: controlPort,
-		GamePort:    gamePort,
-		BedrockPort: bedrockPort,
-	}
-
-	r := relay.New(cfg)
+	r.Log(fmt.Sprintf("Starting Tunnel Relay Server %s (daemon mode)...", tunnel.Version))
+	r.Log(fmt.Sprintf("API: %s", apiURL(cfg.API, apiPort)))
 	r.Start()
 
+	// Reload the ACL file on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			r.ReloadACL()
+		}
+	}()
+
 	// Start API
 	go r.StartAPI(apiPort)
 
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"tunnel/pkg/relay"
+	"tunnel/pkg/tunnel"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -60,7 +61,9 @@ var (
 
 // Messages
 type statusMsg relay.StatusResponse
-type logMsg string
+type connectionsMsg []relay.ConnectionStatus
+type noticeMsg string
+type logMsg relay.LogEvent
 type errMsg error
 type tickMsg time.Time
 
@@ -68,20 +71,113 @@ type logStreamConnectedMsg struct {
 	scanner *bufio.Scanner
 }
 
+// logStreamClosedMsg reports that the log stream ended or could not be
+// opened; the monitor reconnects after logReconnectDelay
+type logStreamClosedMsg struct {
+	err error
+}
+
+type logReconnectMsg struct{}
+
+// apiClient reaches the relay API with the local bind address, token and
+// TLS settings
+type apiClient struct {
+	baseURL   string
+	token     string
+	transport http.RoundTripper
+}
+
+func newAPIClient(cfg relay.APIConfig, port int) (*apiClient, error) {
+	c := &apiClient{
+		baseURL:   apiURL(cfg, port),
+		token:     relay.ClientAPIToken(cfg.Tokens),
+		transport: http.DefaultTransport,
+	}
+	if cfg.TLSCertFile != "" {
+		// Trust the relay's own certificate, whatever name it was issued for
+		tlsConfig, err := tunnel.ClientTLSConfig(cfg.TLSCertFile, "", "")
+		if err != nil {
+			return nil, err
+		}
+		c.transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return c, nil
+}
+
+// newRequest prepares a request for path carrying the client's token
+func (c *apiClient) newRequest(method, path string) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// send sends req, giving up after timeout (0 for none). Any status but
+// 200 OK is an error.
+func (c *apiClient) send(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	client := http.Client{Transport: c.transport, Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// do sends a request for path, giving up after timeout (0 for none)
+func (c *apiClient) do(method, path string, timeout time.Duration) (*http.Response, error) {
+	req, err := c.newRequest(method, path)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(req, timeout)
+}
+
+// Components the log filter cycles through, "" showing all
+var logComponents = []string{"", "Control", "Game", "Bedrock", "ACL", "API", "Webhook"}
+
+// Log events kept for filtering and how many are shown
+const (
+	logHistory = 200
+	logLines   = 20
+)
+
+// How long the monitor waits before reopening a closed log stream
+const logReconnectDelay = 2 * time.Second
+
 type model struct {
-	apiPort   int
+	api       *apiClient
 	status    relay.StatusResponse
-	logs      []string
+	logs      []relay.LogEvent
+	lastLogID uint64 // Resume point when the log stream reconnects
 	err       error
 	scanner   *bufio.Scanner
 	connected bool
+
+	// Player table and kick prompt
+	connections []relay.ConnectionStatus
+	selected    int
+	kickTarget  *relay.ConnectionStatus // Captured when the prompt opens, as the table refreshes under it
+	notice      string
+
+	// Log filter
+	logLevel     relay.LogLevel
+	logComponent int // Index into logComponents
 }
 
-func initialModel(apiPort int) model {
+func initialModel(api *apiClient) model {
 	return model{
-		apiPort:   apiPort,
+		api:       api,
 		status:    relay.StatusResponse{},
-		logs:      []string{},
+		logs:      []relay.LogEvent{},
+		logLevel:  relay.LevelDebug,
 		connected: false,
 	}
 }
@@ -89,7 +185,7 @@ func initialModel(apiPort int) model {
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
 		tickCmd(),
-		connectLogStream(m.apiPort),
+		connectLogStream(m.api, 0),
 	)
 }
 
@@ -99,10 +195,9 @@ func tickCmd() tea.Cmd {
 	})
 }
 
-func getStatus(apiPort int) tea.Cmd {
+func getStatus(api *apiClient) tea.Cmd {
 	return func() tea.Msg {
-		client := http.Client{Timeout: 500 * time.Millisecond}
-		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/status", apiPort))
+		resp, err := api.do(http.MethodGet, "/status", 500*time.Millisecond)
 		if err != nil {
 			return errMsg(err)
 		}
@@ -116,31 +211,83 @@ func getStatus(apiPort int) tea.Cmd {
 	}
 }
 
-func connectLogStream(apiPort int) tea.Cmd {
+func getConnections(api *apiClient) tea.Cmd {
 	return func() tea.Msg {
-		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/logs", apiPort))
+		resp, err := api.do(http.MethodGet, "/connections", 500*time.Millisecond)
 		if err != nil {
 			return errMsg(err)
 		}
+		defer resp.Body.Close()
+
+		var conns []relay.ConnectionStatus
+		if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil {
+			return errMsg(err)
+		}
+		return connectionsMsg(conns)
+	}
+}
+
+func kickPlayer(api *apiClient, c relay.ConnectionStatus) tea.Cmd {
+	return func() tea.Msg {
+		resp, err := api.do(http.MethodDelete, fmt.Sprintf("/connections/%d", c.ID), 2*time.Second)
+		if err != nil {
+			return noticeMsg(fmt.Sprintf("Kick failed: %v", err))
+		}
+		resp.Body.Close()
+		return noticeMsg("Kicked " + playerName(c))
+	}
+}
+
+// playerName names a connection in the player table
+func playerName(c relay.ConnectionStatus) string {
+	if c.Username != "" {
+		return c.Username
+	}
+	return c.RemoteAddr
+}
+
+// connectLogStream opens the log stream, resuming after lastID so the
+// relay replays what was missed while disconnected
+func connectLogStream(api *apiClient, lastID uint64) tea.Cmd {
+	return func() tea.Msg {
+		req, err := api.newRequest(http.MethodGet, "/logs")
+		if err != nil {
+			return logStreamClosedMsg{err: err}
+		}
+		if lastID != 0 {
+			req.Header.Set("Last-Event-ID", fmt.Sprint(lastID))
+		}
+		resp, err := api.send(req, 0)
+		if err != nil {
+			return logStreamClosedMsg{err: err}
+		}
 		// Note: We are not closing body here, it stays open for streaming
 		scanner := bufio.NewScanner(resp.Body)
 		return logStreamConnectedMsg{scanner: scanner}
 	}
 }
 
+// readNextLog reads one line of the SSE stream. Events carry their level
+// and ID in the JSON data, so "event:" and "id:" lines are skipped along
+// with blank ones.
 func readNextLog(scanner *bufio.Scanner) tea.Cmd {
 	return func() tea.Msg {
 		if scanner.Scan() {
-			text := scanner.Text()
-			if strings.HasPrefix(text, "data: ") {
-				return logMsg(strings.TrimPrefix(text, "data: "))
+			var e struct {
+				relay.LogEvent
+				Dropped int64 `json:"dropped"`
 			}
-			return logMsg("") // Skip non-data lines
-		}
-		if err := scanner.Err(); err != nil {
-			return errMsg(err)
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if ok && json.Unmarshal([]byte(data), &e) != nil {
+				// A relay from before structured logs sends plain lines
+				e.LogEvent = relay.LogEvent{Level: relay.LevelInfo, Message: data}
+			}
+			if e.Dropped > 0 {
+				e.LogEvent = relay.LogEvent{Time: time.Now(), Level: relay.LevelWarn, Component: "API", Message: fmt.Sprintf("%d log events dropped", e.Dropped)}
+			}
+			return logMsg(e.LogEvent)
 		}
-		return errMsg(fmt.Errorf("log stream closed"))
+		return logStreamClosedMsg{err: scanner.Err()}
 	}
 }
 
@@ -150,9 +297,49 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.String() == "q" || msg.String() == "ctrl+c" {
 			return m, tea.Quit
 		}
+		if m.kickTarget != nil {
+			target := *m.kickTarget
+			m.kickTarget = nil
+			m.notice = ""
+			if msg.String() == "y" {
+				return m, kickPlayer(m.api, target)
+			}
+			return m, nil
+		}
+		switch msg.String() {
+		case "up":
+			if m.selected > 0 {
+				m.selected--
+			}
+		case "down":
+			if m.selected < len(m.connections)-1 {
+				m.selected++
+			}
+		case "x":
+			if m.selected < len(m.connections) {
+				target := m.connections[m.selected]
+				m.kickTarget = &target
+			}
+		case "l":
+			m.logLevel++
+			if m.logLevel > relay.LevelError {
+				m.logLevel = relay.LevelDebug
+			}
+		case "c":
+			m.logComponent = (m.logComponent + 1) % len(logComponents)
+		}
 
 	case tickMsg:
-		return m, tea.Batch(getStatus(m.apiPort), tickCmd())
+		return m, tea.Batch(getStatus(m.api), getConnections(m.api), tickCmd())
+
+	case connectionsMsg:
+		m.connections = msg
+		if m.selected >= len(m.connections) {
+			m.selected = max(len(m.connections)-1, 0)
+		}
+
+	case noticeMsg:
+		m.notice = string(msg)
 
 	case statusMsg:
 		m.status = relay.StatusResponse(msg)
@@ -163,10 +350,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.scanner = msg.scanner
 		return m, readNextLog(m.scanner)
 
+	case logStreamClosedMsg:
+		// Status polling reports an unreachable relay; just try again
+		m.scanner = nil
+		return m, tea.Tick(logReconnectDelay, func(time.Time) tea.Msg {
+			return logReconnectMsg{}
+		})
+
+	case logReconnectMsg:
+		return m, connectLogStream(m.api, m.lastLogID)
+
 	case logMsg:
-		if string(msg) != "" {
-			m.logs = append(m.logs, string(msg))
-			if len(m.logs) > 20 {
+		if msg.ID != 0 {
+			m.lastLogID = msg.ID
+		}
+		if msg.Message != "" {
+			m.logs = append(m.logs, relay.LogEvent(msg))
+			if len(m.logs) > logHistory {
 				m.logs = m.logs[1:]
 			}
 		}
@@ -181,6 +381,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// logEventStyle colors a log line by level
+func logEventStyle(level relay.LogLevel) lipgloss.Style {
+	switch {
+	case level >= relay.LevelError:
+		return lipgloss.NewStyle().Foreground(errorColor)
+	case level == relay.LevelWarn:
+		return lipgloss.NewStyle().Foreground(warningColor)
+	case level <= relay.LevelDebug:
+		return lipgloss.NewStyle().Foreground(subtleColor)
+	}
+	return logStyle
+}
+
 func formatBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
@@ -199,7 +412,8 @@ func (m model) View() string {
 
 	if m.err != nil && !m.connected {
 		s += lipgloss.NewStyle().Foreground(warningColor).Render("⚠ Server not running or not reachable") + "\n\n"
-		s += lipgloss.NewStyle().Foreground(subtleColor).Render(fmt.Sprintf("Trying to connect to http://localhost:%d...\n", m.apiPort))
+		s += lipgloss.NewStyle().Foreground(subtleColor).Render(fmt.Sprintf("Trying to connect to %s...\n", m.api.baseURL))
+		s += lipgloss.NewStyle().Foreground(subtleColor).Render(fmt.Sprintf("Last error: %v\n", m.err))
 		s += lipgloss.NewStyle().Foreground(subtleColor).Render("Start the server with: tunnel-server start\n")
 		s += "\n" + lipgloss.NewStyle().Foreground(subtleColor).Render("Press 'q' to quit.") + "\n"
 		return appStyle.Render(s)
@@ -223,6 +437,9 @@ func (m model) View() string {
 		statusColor = highlightColor
 	}
 	infoContent += fmt.Sprintf("%s %s", labelStyle.Render("Tunnel:      "), lipgloss.NewStyle().Foreground(statusColor).Bold(true).Render(statusText))
+	if m.status.HostIdentity != "" {
+		infoContent += fmt.Sprintf("\n%s %s", labelStyle.Render("Host:        "), m.status.HostIdentity)
+	}
 
 	infoBox := boxStyle.Render(infoContent)
 
@@ -230,26 +447,96 @@ func (m model) View() string {
 	uptime := time.Duration(m.status.UptimeSeconds) * time.Second
 	statsContent := fmt.Sprintf("%s %s\n", labelStyle.Render("Uptime:        "), uptime.String())
 	statsContent += fmt.Sprintf("%s %d\n", labelStyle.Render("Active Players:"), m.status.ActivePlayers)
+	in := m.status.Traffic.Java.BytesIn + m.status.Traffic.Bedrock.BytesIn
+	out := m.status.Traffic.Java.BytesOut + m.status.Traffic.Bedrock.BytesOut
 	statsContent += fmt.Sprintf("%s %s\n", labelStyle.Render("Traffic:       "), formatBytes(m.status.BytesTransferred))
+	statsContent += fmt.Sprintf("%s %s / %s\n", labelStyle.Render("Up / Down:     "), formatBytes(in), formatBytes(out))
 	statsBox := boxStyle.Render(statsContent)
 
 	// Join Boxes
 	row1 := lipgloss.JoinHorizontal(lipgloss.Top, infoBox, statsBox)
 
-	// Logs
+	// Hosts (primary first, then standbys in failover order)
+	if len(m.status.Hosts) > 0 {
+		var hostContent string
+		for i, h := range m.status.Hosts {
+			name := h.RemoteAddr
+			if h.Identity != "" {
+				name = h.Identity + " (" + h.RemoteAddr + ")"
+			}
+			if len(h.Hostnames) > 0 {
+				name = strings.Join(h.Hostnames, ",") + " " + name
+			}
+			role := lipgloss.NewStyle().Foreground(subtleColor).Render(fmt.Sprintf("%-8s", h.Role))
+			if h.Role != "standby" {
+				role = statusStyle.Render(fmt.Sprintf("%-8s", h.Role))
+			}
+			hostContent += fmt.Sprintf("%s #%-3d %-40s %3d players %6.1f ms", role, h.ID, name, h.Players, h.RTTMillis)
+			if i < len(m.status.Hosts)-1 {
+				hostContent += "\n"
+			}
+		}
+		row1 += "\n" + boxStyle.Render(hostContent)
+	}
+
+	// Players, with the one selected for kicking marked
+	if len(m.connections) > 0 {
+		var playerContent string
+		for i, c := range m.connections {
+			cursor := "  "
+			line := fmt.Sprintf("#%-4d %-16s %-22s %-3s %9s  %s", c.ID, playerName(c), c.RemoteAddr, c.Protocol,
+				formatBytes(c.BytesIn+c.BytesOut), time.Since(time.Unix(c.ConnectedSince, 0)).Round(time.Second))
+			if i == m.selected {
+				cursor = statusStyle.Render("> ")
+				line = lipgloss.NewStyle().Bold(true).Render(line)
+			}
+			playerContent += cursor + line
+			if i < len(m.connections)-1 {
+				playerContent += "\n"
+			}
+		}
+		s += row1 + "\n" + boxStyle.Render(playerContent)
+	} else {
+		s += row1
+	}
+
+	// Logs, newest last, filtered by level and component
+	var shown []relay.LogEvent
+	for _, e := range m.logs {
+		if e.Level >= m.logLevel && (logComponents[m.logComponent] == "" || e.Component == logComponents[m.logComponent]) {
+			shown = append(shown, e)
+		}
+	}
+	if len(shown) > logLines {
+		shown = shown[len(shown)-logLines:]
+	}
 	var logContent string
-	if len(m.logs) == 0 {
+	if len(shown) == 0 {
 		logContent = logStyle.Render("Waiting for logs...")
 	} else {
-		for _, l := range m.logs {
-			logContent += logStyle.Render(l) + "\n"
+		for _, e := range shown {
+			logContent += logEventStyle(e.Level).Render(e.Line()) + "\n"
 		}
 	}
 
-	s += row1 + "\n"
+	s += "\n"
 	s += logBoxStyle.Render(logContent)
 
-	s += "\n\n" + lipgloss.NewStyle().Foreground(subtleColor).Render("Press 'q' to quit.") + "\n"
+	switch {
+	case m.kickTarget != nil:
+		s += "\n\n" + lipgloss.NewStyle().Foreground(warningColor).Render(
+			fmt.Sprintf("Kick %s? (y/n)", playerName(*m.kickTarget))) + "\n"
+	case m.notice != "":
+		s += "\n\n" + lipgloss.NewStyle().Foreground(warningColor).Render(m.notice) + "\n"
+	default:
+		s += "\n\n"
+	}
+	component := logComponents[m.logComponent]
+	if component == "" {
+		component = "all"
+	}
+	s += lipgloss.NewStyle().Foreground(subtleColor).Render(fmt.Sprintf(
+		"↑/↓: Select player • x: Kick • l: Level (%s+) • c: Component (%s) • q: Quit", m.logLevel, component)) + "\n"
 
 	return appStyle.Render(s)
 }
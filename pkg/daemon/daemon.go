@@ -30,6 +30,60 @@ func DefaultLogFile() string {
 	return filepath.Join(home, ".tunnel-relay.log")
 }
 
+// DefaultTLSCertFile returns the default control channel certificate path
+func DefaultTLSCertFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/tunnel-relay-cert.pem"
+	}
+	return filepath.Join(home, ".tunnel-relay-cert.pem")
+}
+
+// DefaultTLSKeyFile returns the default control channel private key path
+func DefaultTLSKeyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/tunnel-relay-key.pem"
+	}
+	return filepath.Join(home, ".tunnel-relay-key.pem")
+}
+
+// DefaultCADir returns the default directory of the host certificate authority
+func DefaultCADir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/tunnel-relay-ca"
+	}
+	return filepath.Join(home, ".tunnel-relay-ca")
+}
+
+// DefaultACLFile returns the default player allow/deny list path
+func DefaultACLFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/tunnel-relay-acl.json"
+	}
+	return filepath.Join(home, ".tunnel-relay-acl.json")
+}
+
+// DefaultAPITokensFile returns the default API bearer tokens path
+func DefaultAPITokensFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/tunnel-relay-api-tokens.json"
+	}
+	return filepath.Join(home, ".tunnel-relay-api-tokens.json")
+}
+
+// DefaultWebhooksFile returns the default event webhooks path
+func DefaultWebhooksFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/tunnel-relay-webhooks.json"
+	}
+	return filepath.Join(home, ".tunnel-relay-webhooks.json")
+}
+
 // WritePid writes the current process PID to the pid file
 func WritePid(pidFile string) error {
 	return os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
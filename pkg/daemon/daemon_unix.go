@@ -9,8 +9,9 @@ import (
 	"syscall"
 )
 
-// Start starts the daemon process in the background
-func Start(pidFile string, logFile string, args []string) error {
+// Start starts the daemon process in the background. env is added to the
+// daemon's environment.
+func Start(pidFile string, logFile string, args []string, env []string) error {
 	if running, pid := IsRunning(pidFile); running {
 		return fmt.Errorf("%w: PID %d", ErrAlreadyRunning, pid)
 	}
@@ -25,6 +26,7 @@ func Start(pidFile string, logFile string, args []string) error {
 	daemonArgs := append([]string{"--daemon"}, args...)
 
 	cmd := exec.Command(executable, daemonArgs...)
+	cmd.Env = append(os.Environ(), env...)
 
 	// Open log file for stdout/stderr
 	logFd, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
@@ -0,0 +1,138 @@
+// Package proxyproto writes HAProxy PROXY protocol headers so local servers
+// see the real player address instead of the tunnel client's.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// signature starts every version 2 header
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Version 2 command and address family/transport bytes
+const (
+	cmdLocal = 0x20
+	cmdProxy = 0x21
+
+	famUnspec = 0x00
+	famTCP4   = 0x11
+	famUDP4   = 0x12
+	famTCP6   = 0x21
+	famUDP6   = 0x22
+)
+
+// ParseVersion parses a --proxy-protocol value: "" or "off" disables the
+// header (0), "v1" and "v2" select a version
+func ParseVersion(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "off", "none":
+		return 0, nil
+	case "v1", "1":
+		return 1, nil
+	case "v2", "2":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("unknown PROXY protocol version %q (want v1 or v2)", s)
+}
+
+// Header builds a header of the given version announcing a connection from
+// src to dst. Either address may be nil when unknown, in which case the
+// header tells the server to use the real connection addresses.
+func Header(version int, src, dst net.Addr) ([]byte, error) {
+	switch version {
+	case 1:
+		return headerV1(src, dst), nil
+	case 2:
+		return headerV2(src, dst), nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+}
+
+// endpoints extracts IPs and ports, mapping both to IPv6 when the families
+// differ. ok is false if either address is missing.
+func endpoints(src, dst net.Addr) (srcIP, dstIP net.IP, srcPort, dstPort int, udp, ok bool) {
+	switch s := src.(type) {
+	case *net.TCPAddr:
+		srcIP, srcPort = s.IP, s.Port
+	case *net.UDPAddr:
+		srcIP, srcPort, udp = s.IP, s.Port, true
+	default:
+		return
+	}
+	switch d := dst.(type) {
+	case *net.TCPAddr:
+		dstIP, dstPort = d.IP, d.Port
+	case *net.UDPAddr:
+		dstIP, dstPort = d.IP, d.Port
+	default:
+		return
+	}
+	if srcIP == nil || dstIP == nil {
+		return
+	}
+
+	if s4, d4 := srcIP.To4(), dstIP.To4(); s4 != nil && d4 != nil {
+		srcIP, dstIP = s4, d4
+	} else {
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	}
+	return srcIP, dstIP, srcPort, dstPort, udp, true
+}
+
+func headerV1(src, dst net.Addr) []byte {
+	srcIP, dstIP, srcPort, dstPort, udp, ok := endpoints(src, dst)
+	if !ok || udp {
+		// Version 1 only describes TCP
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	if len(srcIP) == net.IPv4len {
+		return fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", srcIP, dstIP, srcPort, dstPort)
+	}
+	return fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", formatIPv6(srcIP), formatIPv6(dstIP), srcPort, dstPort)
+}
+
+// formatIPv6 keeps IPv4-mapped addresses in IPv6 notation, which
+// net.IP.String would print as dotted IPv4
+func formatIPv6(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
+
+func headerV2(src, dst net.Addr) []byte {
+	var buf bytes.Buffer
+	buf.Write(signature)
+
+	srcIP, dstIP, srcPort, dstPort, udp, ok := endpoints(src, dst)
+	if !ok {
+		buf.Write([]byte{cmdLocal, famUnspec, 0, 0})
+		return buf.Bytes()
+	}
+
+	ipv4 := len(srcIP) == net.IPv4len
+	family := byte(famTCP4)
+	switch {
+	case ipv4 && udp:
+		family = famUDP4
+	case !ipv4 && udp:
+		family = famUDP6
+	case !ipv4:
+		family = famTCP6
+	}
+
+	addrLen := 2*len(srcIP) + 4
+	buf.Write([]byte{cmdProxy, family})
+	binary.Write(&buf, binary.BigEndian, uint16(addrLen))
+	buf.Write(srcIP)
+	buf.Write(dstIP)
+	binary.Write(&buf, binary.BigEndian, uint16(srcPort))
+	binary.Write(&buf, binary.BigEndian, uint16(dstPort))
+	return buf.Bytes()
+}
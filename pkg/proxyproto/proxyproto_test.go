@@ -0,0 +1,158 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+func tcp(addr string) *net.TCPAddr {
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func udp(addr string) *net.UDPAddr {
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// v2 joins the signature with the hex-encoded rest of a version 2 header
+func v2(rest string) []byte {
+	b, err := hex.DecodeString(rest)
+	if err != nil {
+		panic(err)
+	}
+	return append(append([]byte{}, signature...), b...)
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"off", 0, false},
+		{"none", 0, false},
+		{"v1", 1, false},
+		{"1", 1, false},
+		{"V2", 2, false},
+		{"2", 2, false},
+		{"v3", 0, true},
+		{"yes", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseVersion(%q) = %d, %v, want %d (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHeaderV1(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		want     string
+	}{
+		{"IPv4", tcp("203.0.113.7:51234"), tcp("10.0.0.2:25565"), "PROXY TCP4 203.0.113.7 10.0.0.2 51234 25565\r\n"},
+		{"IPv6", tcp("[2001:db8::1]:51234"), tcp("[2001:db8::2]:25565"), "PROXY TCP6 2001:db8::1 2001:db8::2 51234 25565\r\n"},
+		{"mixed families", tcp("203.0.113.7:51234"), tcp("[2001:db8::2]:25565"), "PROXY TCP6 ::ffff:203.0.113.7 2001:db8::2 51234 25565\r\n"},
+		{"UDP", udp("203.0.113.7:51234"), udp("10.0.0.2:19132"), "PROXY UNKNOWN\r\n"},
+		{"unknown source", nil, tcp("10.0.0.2:25565"), "PROXY UNKNOWN\r\n"},
+		{"unknown destination", tcp("203.0.113.7:51234"), nil, "PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Header(1, tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("header = %q, want %q", got, tt.want)
+			}
+			// The spec caps version 1 headers at 107 bytes
+			if len(got) > 107 {
+				t.Errorf("header is %d bytes, over the 107 byte limit", len(got))
+			}
+		})
+	}
+}
+
+func TestHeaderV2(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		want     []byte
+	}{
+		{
+			name: "TCP over IPv4",
+			src:  tcp("203.0.113.7:51234"),
+			dst:  tcp("10.0.0.2:25565"),
+			// PROXY, TCP4, 12 address bytes, addresses, ports
+			want: v2("2111000c" + "cb007107" + "0a000002" + "c822" + "63dd"),
+		},
+		{
+			name: "UDP over IPv4",
+			src:  udp("203.0.113.7:51234"),
+			dst:  udp("10.0.0.2:19132"),
+			want: v2("2112000c" + "cb007107" + "0a000002" + "c822" + "4abc"),
+		},
+		{
+			name: "TCP over IPv6",
+			src:  tcp("[2001:db8::1]:51234"),
+			dst:  tcp("[2001:db8::2]:25565"),
+			want: v2("21210024" +
+				"20010db8000000000000000000000001" +
+				"20010db8000000000000000000000002" +
+				"c822" + "63dd"),
+		},
+		{
+			name: "UDP with mixed families",
+			src:  udp("203.0.113.7:51234"),
+			dst:  udp("[2001:db8::2]:19132"),
+			want: v2("21220024" +
+				"00000000000000000000ffffcb007107" +
+				"20010db8000000000000000000000002" +
+				"c822" + "4abc"),
+		},
+		{
+			name: "unknown source",
+			src:  nil,
+			dst:  tcp("10.0.0.2:25565"),
+			// LOCAL with no addresses: the server uses the real connection's
+			want: v2("20000000"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Header(2, tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("header = %x, want %x", got, tt.want)
+			}
+			// The length field covers exactly the bytes after the 16-byte
+			// fixed header
+			if n := int(got[14])<<8 | int(got[15]); n != len(got)-16 {
+				t.Errorf("length field %d, but %d address bytes follow", n, len(got)-16)
+			}
+		})
+	}
+}
+
+func TestHeaderUnsupportedVersion(t *testing.T) {
+	for _, version := range []int{0, 3, -1} {
+		if _, err := Header(version, tcp("203.0.113.7:51234"), tcp("10.0.0.2:25565")); err == nil {
+			t.Errorf("Header(%d) succeeded, want an error", version)
+		}
+	}
+}
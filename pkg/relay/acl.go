@@ -0,0 +1,400 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ACL lists are checked before a player's first packet reaches the limits
+// or a host: deny and bans always win, and a non-empty allow list admits
+// only the addresses on it. Java players are checked against the name
+// lists again once their Login Start packet names them.
+const (
+	ACLAllow     = "allow"
+	ACLDeny      = "deny"
+	ACLBan       = "ban"
+	ACLAllowName = "allow-name"
+	ACLDenyName  = "deny-name"
+)
+
+// ACLRules is the content of the ACL file. Address entries are IPs or
+// CIDRs; name entries are Java usernames or UUIDs.
+type ACLRules struct {
+	Allow      []string `json:"allow"`
+	Deny       []string `json:"deny"`
+	Bans       []Ban    `json:"bans"`
+	AllowNames []string `json:"allow_names"`
+	DenyNames  []string `json:"deny_names"`
+}
+
+// list returns the string list called name, nil for bans or unknown names
+func (r *ACLRules) list(name string) *[]string {
+	switch name {
+	case ACLAllow:
+		return &r.Allow
+	case ACLDeny:
+		return &r.Deny
+	case ACLAllowName:
+		return &r.AllowNames
+	case ACLDenyName:
+		return &r.DenyNames
+	}
+	return nil
+}
+
+// Ban is a deny entry with a reason and an optional expiry
+type Ban struct {
+	Address string    `json:"address"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"` // Zero for a permanent ban
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.Expires.IsZero() && now.After(b.Expires)
+}
+
+// ACL holds the relay's allow and deny lists and writes changes made
+// through the API back to its file
+type ACL struct {
+	path string // "" keeps the lists in memory only
+
+	mu    sync.RWMutex
+	rules ACLRules
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	bans  []*net.IPNet // Parallel to rules.Bans
+}
+
+// LoadACL reads an ACL file. A missing file gives empty lists, and is
+// created the first time they change.
+func LoadACL(path string) (*ACL, error) {
+	acl := &ACL{path: path}
+	if err := acl.Reload(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// Reload rereads the ACL file, keeping the current lists if it is invalid
+func (a *ACL) Reload() error {
+	if a.path == "" {
+		return nil
+	}
+	var rules ACLRules
+	data, err := os.ReadFile(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return fmt.Errorf("invalid ACL file %s: %w", a.path, err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.set(rules)
+}
+
+// set parses and installs rules, normalizing every address. Called with
+// a.mu held.
+func (a *ACL) set(rules ACLRules) error {
+	var err error
+	var allow, deny, bans []*net.IPNet
+	if rules.Allow, allow, err = parseAddresses(rules.Allow); err != nil {
+		return err
+	}
+	if rules.Deny, deny, err = parseAddresses(rules.Deny); err != nil {
+		return err
+	}
+	if rules.AllowNames, err = parseNames(rules.AllowNames); err != nil {
+		return err
+	}
+	if rules.DenyNames, err = parseNames(rules.DenyNames); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rules.Bans = slices.DeleteFunc(append([]Ban{}, rules.Bans...), func(b Ban) bool { return b.expired(now) })
+	for i, b := range rules.Bans {
+		address, network, err := parseAddress(b.Address)
+		if err != nil {
+			return err
+		}
+		rules.Bans[i].Address = address
+		bans = append(bans, network)
+	}
+
+	a.rules, a.allow, a.deny, a.bans = rules, allow, deny, bans
+	return nil
+}
+
+// parseAddress accepts an IP or CIDR and returns it in canonical form
+func parseAddress(s string) (string, *net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, 32
+		}
+		return ip.String(), &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid address %q (want an IP or CIDR)", s)
+	}
+	return network.String(), network, nil
+}
+
+func parseAddresses(list []string) ([]string, []*net.IPNet, error) {
+	addresses := []string{}
+	var networks []*net.IPNet
+	for _, s := range list {
+		address, network, err := parseAddress(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if slices.Contains(addresses, address) {
+			continue
+		}
+		addresses = append(addresses, address)
+		networks = append(networks, network)
+	}
+	return addresses, networks, nil
+}
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,16}$`)
+	uuidPattern     = regexp.MustCompile(`^[0-9a-f]{8}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{12}$`)
+)
+
+// parseName accepts a Java username or UUID and returns it lowercased,
+// with dashes for a UUID
+func parseName(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if uuidPattern.MatchString(s) {
+		s = strings.ReplaceAll(s, "-", "")
+		return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+	}
+	if !usernamePattern.MatchString(s) {
+		return "", fmt.Errorf("invalid player %q (want a username or UUID)", s)
+	}
+	return s, nil
+}
+
+func parseNames(list []string) ([]string, error) {
+	names := []string{}
+	for _, s := range list {
+		name, err := parseName(s)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// parseEntry normalizes an entry for the named list
+func parseEntry(list, s string) (string, error) {
+	switch list {
+	case ACLAllow, ACLDeny, ACLBan:
+		address, _, err := parseAddress(s)
+		return address, err
+	case ACLAllowName, ACLDenyName:
+		return parseName(s)
+	}
+	return "", fmt.Errorf("unknown list %q (want allow, deny, ban, allow-name or deny-name)", list)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether ip may connect, and why not if it may not
+func (a *ACL) Check(ip net.IP) (bool, string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	now := time.Now()
+	for i, n := range a.bans {
+		if b := a.rules.Bans[i]; n.Contains(ip) && !b.expired(now) {
+			reason := "banned"
+			if !b.Expires.IsZero() {
+				reason += " until " + b.Expires.Format(time.RFC3339)
+			}
+			if b.Reason != "" {
+				reason += ": " + b.Reason
+			}
+			return false, reason
+		}
+	}
+	if containsIP(a.deny, ip) {
+		return false, "denied"
+	}
+	if len(a.allow) > 0 && !containsIP(a.allow, ip) {
+		return false, "not on the allow list"
+	}
+	return true, ""
+}
+
+// CheckName reports whether a Java player may log in, and why not if they
+// may not. Entries match the username case-insensitively or the UUID.
+func (a *ACL) CheckName(name, uuid string) (bool, string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	matches := func(list []string) bool {
+		return (name != "" && slices.Contains(list, strings.ToLower(name))) ||
+			(uuid != "" && slices.Contains(list, strings.ToLower(uuid)))
+	}
+	if matches(a.rules.DenyNames) {
+		return false, "name denied"
+	}
+	if len(a.rules.AllowNames) > 0 && !matches(a.rules.AllowNames) {
+		return false, "name not on the allow list"
+	}
+	return true, ""
+}
+
+// Rules returns a copy of the lists without expired bans
+func (a *ACL) Rules() ACLRules {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	now := time.Now()
+	rules := a.editable()
+	rules.Bans = slices.DeleteFunc(rules.Bans, func(b Ban) bool { return b.expired(now) })
+	return rules
+}
+
+// Add puts entry on a list. Bans take a reason and last for duration
+// (forever when 0); banning an address again replaces its ban.
+func (a *ACL) Add(list, entry, reason string, duration time.Duration) error {
+	entry, err := parseEntry(list, entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rules := a.editable()
+	if list == ACLBan {
+		ban := Ban{Address: entry, Reason: reason, Created: time.Now()}
+		if duration > 0 {
+			ban.Expires = ban.Created.Add(duration)
+		}
+		rules.Bans = slices.DeleteFunc(rules.Bans, func(b Ban) bool { return b.Address == entry })
+		rules.Bans = append(rules.Bans, ban)
+	} else if l := rules.list(list); !slices.Contains(*l, entry) {
+		*l = append(*l, entry)
+	}
+	return a.commit(rules)
+}
+
+// Remove takes entry off a list, reporting whether it was there
+func (a *ACL) Remove(list, entry string) (bool, error) {
+	entry, err := parseEntry(list, entry)
+	if err != nil {
+		return false, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rules := a.editable()
+	var removed bool
+	if list == ACLBan {
+		removed = slices.ContainsFunc(rules.Bans, func(b Ban) bool { return b.Address == entry })
+		rules.Bans = slices.DeleteFunc(rules.Bans, func(b Ban) bool { return b.Address == entry })
+	} else if l := rules.list(list); slices.Contains(*l, entry) {
+		removed = true
+		*l = slices.DeleteFunc(*l, func(s string) bool { return s == entry })
+	}
+	if !removed {
+		return false, nil
+	}
+	return true, a.commit(rules)
+}
+
+// editable copies the current lists for changing. Called with a.mu held.
+func (a *ACL) editable() ACLRules {
+	return ACLRules{
+		Allow:      append([]string{}, a.rules.Allow...),
+		Deny:       append([]string{}, a.rules.Deny...),
+		Bans:       append([]Ban{}, a.rules.Bans...),
+		AllowNames: append([]string{}, a.rules.AllowNames...),
+		DenyNames:  append([]string{}, a.rules.DenyNames...),
+	}
+}
+
+// commit saves rules to the file and installs them. Called with a.mu held.
+func (a *ACL) commit(rules ACLRules) error {
+	previous := a.rules
+	if err := a.set(rules); err != nil {
+		return err
+	}
+	if a.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.rules, "", "  ")
+	if err == nil {
+		// Write and rename so a reload never sees half a file
+		tmp := filepath.Join(filepath.Dir(a.path), "."+filepath.Base(a.path)+".tmp")
+		if err = os.WriteFile(tmp, append(data, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, a.path)
+		}
+	}
+	if err != nil {
+		a.set(previous)
+		return fmt.Errorf("failed to save ACL: %w", err)
+	}
+	return nil
+}
+
+// ReloadACL rereads the ACL file, typically on SIGHUP
+func (r *Relay) ReloadACL() {
+	if err := r.acl.Reload(); err != nil {
+		r.logf(LevelWarn, "ACL", nil, "Reload failed, keeping current lists: %v", err)
+		return
+	}
+	rules := r.acl.Rules()
+	r.logf(LevelInfo, "ACL", nil, "Reloaded: %d allowed, %d denied, %d banned, %d names allowed, %d names denied",
+		len(rules.Allow), len(rules.Deny), len(rules.Bans), len(rules.AllowNames), len(rules.DenyNames))
+}
+
+// checkACL reports whether a player from addr passes the ACL, logging
+// refusals at the same reduced rate as the connection limits
+func (r *Relay) checkACL(component string, addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return true
+	}
+	ok, reason := r.acl.Check(ip)
+	if ok {
+		return true
+	}
+
+	atomic.AddInt64(&r.aclRejected, 1)
+	if ok, suppressed := r.rejectLog.allow(); ok {
+		r.logRejection(EventACLDenied, component, addr, reason, suppressed)
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package relay
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"203.0.113.7", "203.0.113.7", false},
+		{"::ffff:203.0.113.7", "203.0.113.7", false},
+		{"2001:DB8::1", "2001:db8::1", false},
+		{"203.0.113.0/24", "203.0.113.0/24", false},
+		{"203.0.113.77/24", "203.0.113.0/24", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"", "", true},
+		{"203.0.113", "", true},
+		{"203.0.113.0/33", "", true},
+		{"mc.example.com", "", true},
+	}
+	for _, tt := range tests {
+		got, _, err := parseAddress(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAddress(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"Steve", "steve", false},
+		{"Player_123", "player_123", false},
+		{"a", "a", false},
+		{"sixteen_chars_ok", "sixteen_chars_ok", false},
+		{"seventeen_chars_x", "", true},
+		{"", "", true},
+		{"bad-name", "", true},
+		{"069A79F444E94726A5BEFCA90E38AAF5", "069a79f4-44e9-4726-a5be-fca90e38aaf5", false},
+		{"069a79f4-44e9-4726-a5be-fca90e38aaf5", "069a79f4-44e9-4726-a5be-fca90e38aaf5", false},
+		{"069a79f4-44e9-4726-a5be-fca90e38aaf", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseName(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseName(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseEntryUnknownList(t *testing.T) {
+	if _, err := parseEntry("block", "203.0.113.7"); err == nil {
+		t.Error("unknown list accepted")
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	acl := &ACL{}
+	for _, e := range []struct{ list, entry, reason string }{
+		{ACLAllow, "203.0.113.0/24", ""},
+		{ACLAllow, "2001:db8::/32", ""},
+		{ACLDeny, "203.0.113.66", ""},
+		{ACLBan, "203.0.113.99", "griefing"},
+	} {
+		if err := acl.Add(e.list, e.entry, e.reason, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := acl.Add(ACLBan, "203.0.113.100", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip         string
+		want       bool
+		wantReason string // Prefix of the reason
+	}{
+		{"203.0.113.7", true, ""},
+		{"::ffff:203.0.113.7", true, ""},
+		{"2001:db8::1", true, ""},
+		{"198.51.100.1", false, "not on the allow list"},
+		{"2001:db9::1", false, "not on the allow list"},
+		{"203.0.113.66", false, "denied"},
+		{"203.0.113.99", false, "banned: griefing"},
+		{"203.0.113.100", false, "banned until "},
+	}
+	for _, tt := range tests {
+		ok, reason := acl.Check(net.ParseIP(tt.ip))
+		if ok != tt.want || !strings.HasPrefix(reason, tt.wantReason) || (tt.want && reason != "") {
+			t.Errorf("Check(%s) = %v %q, want %v %q", tt.ip, ok, reason, tt.want, tt.wantReason)
+		}
+	}
+}
+
+func TestACLExpiredBan(t *testing.T) {
+	acl := &ACL{}
+	if err := acl.Add(ACLBan, "203.0.113.7", "", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if ok, reason := acl.Check(net.ParseIP("203.0.113.7")); !ok {
+		t.Errorf("expired ban still applies: %s", reason)
+	}
+	if bans := acl.Rules().Bans; len(bans) != 0 {
+		t.Errorf("Rules() lists %d expired bans", len(bans))
+	}
+}
+
+func TestACLCheckName(t *testing.T) {
+	const uuid = "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+	acl := &ACL{}
+	acl.Add(ACLAllowName, "Steve", "", 0)
+	acl.Add(ACLAllowName, strings.ReplaceAll(uuid, "-", ""), "", 0)
+	acl.Add(ACLDenyName, "Griefer", "", 0)
+
+	tests := []struct {
+		name, uuid string
+		want       bool
+		wantReason string
+	}{
+		{"Steve", "", true, ""},
+		{"STEVE", "", true, ""},
+		{"Renamed", uuid, true, ""},
+		{"Alex", "", false, "name not on the allow list"},
+		{"griefer", "", false, "name denied"},
+		{"", "", false, "name not on the allow list"},
+	}
+	for _, tt := range tests {
+		ok, reason := acl.CheckName(tt.name, tt.uuid)
+		if ok != tt.want || reason != tt.wantReason {
+			t.Errorf("CheckName(%q, %q) = %v %q, want %v %q", tt.name, tt.uuid, ok, reason, tt.want, tt.wantReason)
+		}
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	acl, err := LoadACL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Add(ACLDeny, "::ffff:203.0.113.7", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Add(ACLDeny, "not an address", "", 0); err == nil {
+		t.Error("invalid address accepted")
+	}
+
+	reloaded, err := LoadACL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deny := reloaded.Rules().Deny; len(deny) != 1 || deny[0] != "203.0.113.7" {
+		t.Errorf("reloaded deny list = %q, want [203.0.113.7]", deny)
+	}
+
+	if removed, err := reloaded.Remove(ACLDeny, "203.0.113.7"); !removed || err != nil {
+		t.Errorf("Remove = %v, %v", removed, err)
+	}
+	if removed, _ := reloaded.Remove(ACLDeny, "203.0.113.7"); removed {
+		t.Error("removed the same entry twice")
+	}
+	if ok, _ := reloaded.Check(net.ParseIP("203.0.113.7")); !ok {
+		t.Error("removed entry still denies")
+	}
+}
@@ -3,80 +3,255 @@ package relay
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
+
+	"tunnel/pkg/tunnel"
 )
 
 type StatusResponse struct {
-	PublicIP         string `json:"public_ip"`
-	ControlPort      int    `json:"control_port"`
-	GamePort         int    `json:"game_port"`
-	BedrockPort      int    `json:"bedrock_port,omitempty"`
-	ActivePlayers    int64  `json:"active_players"`
-	BytesTransferred int64  `json:"bytes_transferred"`
-	TunnelConnected  bool   `json:"tunnel_connected"`
-	UptimeSeconds    int64  `json:"uptime_seconds"`
+	PublicIP         string                `json:"public_ip"`
+	ControlPort      int                   `json:"control_port"`
+	GamePort         int                   `json:"game_port"`
+	BedrockPort      int                   `json:"bedrock_port,omitempty"`
+	ActivePlayers    int64                 `json:"active_players"`
+	BytesTransferred int64                 `json:"bytes_transferred"` // Sum of all traffic bytes
+	Traffic          TrafficStatus         `json:"traffic"`
+	BedrockSessions  int                   `json:"bedrock_sessions,omitempty"`
+	BedrockReaped    int64                 `json:"bedrock_sessions_reaped,omitempty"`
+	BedrockClosed    int64                 `json:"bedrock_sessions_closed,omitempty"`
+	Rejected         RejectionCounts       `json:"rejected_connections"`
+	TunnelConnected  bool                  `json:"tunnel_connected"`
+	HostIdentity     string                `json:"host_identity,omitempty"`
+	HostVersion      string                `json:"host_version,omitempty"`
+	RelayVersion     string                `json:"relay_version"`
+	HostPolicy       string                `json:"host_policy"`
+	BalanceStrategy  string                `json:"balance_strategy,omitempty"`
+	Hosts            []HostStatus          `json:"hosts"`
+	LogSubscribers   []LogSubscriberStatus `json:"log_subscribers"`
+	UptimeSeconds    int64                 `json:"uptime_seconds"`
+}
+
+// TrafficStatus splits StatusResponse's byte total by protocol
+type TrafficStatus struct {
+	Java    Traffic `json:"java"`
+	Bedrock Traffic `json:"bedrock"`
 }
 
 func (r *Relay) StartAPI(port int) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status", r.handleStatus)
 	mux.HandleFunc("/logs", r.handleLogs)
+	mux.HandleFunc("/logs/history", r.handleLogHistory)
+	mux.HandleFunc("/events", r.handleEvents)
+	mux.HandleFunc("/metrics", r.handleMetrics)
+	mux.HandleFunc("/acl", r.handleACL)
+	mux.HandleFunc("/connections", r.handleConnections)
+	mux.HandleFunc("DELETE /connections/{id}", r.handleKick)
 
+	bind := r.Config.API.Bind
+	if bind == "" {
+		bind = DefaultAPIBind
+	}
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:    net.JoinHostPort(bind, fmt.Sprint(port)),
+		Handler: r.requireToken(mux),
 	}
 
-	r.Log(fmt.Sprintf("[API] Listening on :%d", port))
-	if err := server.ListenAndServe(); err != nil {
-		r.Log(fmt.Sprintf("[API] Server failed: %v", err))
+	if len(r.Config.API.Tokens) == 0 {
+		if ip := net.ParseIP(bind); ip == nil || !ip.IsLoopback() {
+			r.logf(LevelWarn, "API", nil, "No API tokens configured, anyone who can reach %s has full access", server.Addr)
+		}
+	}
+
+	var err error
+	if r.Config.API.TLSCertFile != "" {
+		r.logf(LevelInfo, "API", nil, "Listening on %s (TLS)", server.Addr)
+		err = server.ListenAndServeTLS(r.Config.API.TLSCertFile, r.Config.API.TLSKeyFile)
+	} else {
+		r.logf(LevelInfo, "API", nil, "Listening on %s", server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		r.logf(LevelError, "API", nil, "Server failed: %v", err)
 	}
 }
 
 func (r *Relay) handleStatus(w http.ResponseWriter, req *http.Request) {
-	r.tunnelMutex.Lock()
-	connected := r.tunnelSession != nil && !r.tunnelSession.IsClosed()
-	r.tunnelMutex.Unlock()
+	host := r.hosts.primary()
+	connected := host != nil
+	identity, clientVersion := "", ""
+	if host != nil {
+		identity, clientVersion = host.identity, host.clientVersion
+	}
+	traffic := TrafficStatus{Java: r.javaTraffic.snapshot(), Bedrock: r.bedrockTraffic.snapshot()}
 
 	status := StatusResponse{
 		PublicIP:         r.PublicIP,
 		ControlPort:      r.Config.ControlPort,
 		GamePort:         r.Config.GamePort,
 		BedrockPort:      r.Config.BedrockPort,
-		ActivePlayers:    atomic.LoadInt64(&r.ActivePlayers),
-		BytesTransferred: atomic.LoadInt64(&r.GlobalBytes),
+		ActivePlayers:    int64(r.connections.count()),
+		BytesTransferred: traffic.Java.BytesIn + traffic.Java.BytesOut + traffic.Bedrock.BytesIn + traffic.Bedrock.BytesOut,
+		Traffic:          traffic,
+		BedrockSessions:  r.BedrockSessions(),
+		BedrockReaped:    atomic.LoadInt64(&r.BedrockReaped),
+		BedrockClosed:    atomic.LoadInt64(&r.BedrockClosed),
+		Rejected:         r.rejectionCounts(),
 		TunnelConnected:  connected,
+		HostIdentity:     identity,
+		HostVersion:      clientVersion,
+		RelayVersion:     tunnel.Version,
+		HostPolicy:       r.Config.HostPolicy,
+		Hosts:            r.hostStatuses(),
+		LogSubscribers:   r.logBroadcaster.Subscribers(),
 		UptimeSeconds:    int64(time.Since(r.StartTime).Seconds()),
 	}
 
+	if r.Config.HostPolicy == HostPolicyBalance {
+		status.BalanceStrategy = r.Config.BalanceStrategy
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
+// writeError sends an error as {"error": ..., "message": ...}
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status), "message": message})
+}
+
+// rejectionCounts combines the limiter's counters with ACL refusals
+func (r *Relay) rejectionCounts() RejectionCounts {
+	counts := r.limits.counts()
+	counts.ACL = atomic.LoadInt64(&r.aclRejected)
+	return counts
+}
+
+// ACLRequest adds an entry through POST /acl
+type ACLRequest struct {
+	List     string `json:"list"`              // allow, deny, ban, allow-name or deny-name
+	Address  string `json:"address,omitempty"` // IP or CIDR for allow, deny and ban
+	Name     string `json:"name,omitempty"`    // Username or UUID for allow-name and deny-name
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration,omitempty"` // Ban length such as "1h" (permanent when empty)
+}
+
+// handleACL lists (GET), adds to (POST) and removes from (DELETE
+// ?list=&address=) the access lists
+func (r *Relay) handleACL(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body ACLRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		var duration time.Duration
+		if body.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(body.Duration); err != nil || duration < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid duration %q", body.Duration))
+				return
+			}
+		}
+		entry := body.Address
+		if body.Name != "" {
+			entry = body.Name
+		}
+		if err := r.acl.Add(body.List, entry, body.Reason, duration); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.logf(LevelInfo, "ACL", nil, "Added %s to %s list", entry, body.List)
+	case http.MethodDelete:
+		query := req.URL.Query()
+		list, entry := query.Get("list"), query.Get("address")
+		if query.Has("name") {
+			entry = query.Get("name")
+		}
+		removed, err := r.acl.Remove(list, entry)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !removed {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s is not on the %s list", entry, list))
+			return
+		}
+		r.logf(LevelInfo, "ACL", nil, "Removed %s from %s list", entry, list)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.acl.Rules())
+}
+
 func (r *Relay) handleLogs(w http.ResponseWriter, req *http.Request) {
+	// ?format=text keeps the plain "[Component] message" lines and
+	// ?level= skips events below that level
+	text := req.URL.Query().Get("format") == "text"
+	minLevel := LevelDebug
+	if s := req.URL.Query().Get("level"); s != "" {
+		level, err := ParseLogLevel(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		minLevel = level
+	}
+
 	// SSE implementation
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	ch := r.logBroadcaster.Subscribe()
-	defer r.logBroadcaster.Unsubscribe(ch)
+	// A reconnecting EventSource resumes after the last event it saw
+	var lastID uint64
+	if s := req.Header.Get("Last-Event-ID"); s != "" {
+		lastID, _ = strconv.ParseUint(s, 10, 64)
+	}
+	sub, backlog := r.logBroadcaster.Subscribe(req.RemoteAddr, lastID)
+	defer r.logBroadcaster.Unsubscribe(sub)
 
-	// Send initial connection message
-	fmt.Fprintf(w, "data: Connected to log stream\n\n")
+	// Send initial connection message, then the kept events the client
+	// has not seen
+	writeSSE(w, LogEvent{Time: time.Now(), Level: LevelInfo, Component: "API", Message: "Connected to log stream"}, text)
+	if len(backlog) > 0 && backlog[0].ID > lastID+1 && lastID != 0 {
+		writeSSE(w, LogEvent{Time: time.Now(), Level: LevelWarn, Component: "API", Message: "Some events since the last one received are no longer kept"}, text)
+	}
+	for _, e := range backlog {
+		if e.Level >= minLevel {
+			writeSSE(w, e, text)
+		}
+	}
 	if f, ok := w.(http.Flusher); ok {
 		f.Flush()
 	}
 
 	for {
 		select {
-		case msg, ok := <-ch:
+		case e, ok := <-sub.C:
 			if !ok {
 				return
 			}
-			fmt.Fprintf(w, "data: %s\n\n", msg)
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				writeDropped(w, dropped, text)
+			}
+			if e.Level < minLevel {
+				continue
+			}
+			writeSSE(w, e, text)
 			if f, ok := w.(http.Flusher); ok {
 				f.Flush()
 			}
@@ -85,3 +260,63 @@ func (r *Relay) handleLogs(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 }
+
+// handleLogHistory serves GET /logs/history: kept events after ?since=
+// (or the most recent ones without it), at most ?limit=, optionally only
+// from ?component= and at or above ?level=
+func (r *Relay) handleLogHistory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := req.URL.Query()
+	var since uint64
+	if s := query.Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since parameter")
+			return
+		}
+	}
+	limit := 100
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+	minLevel := LevelDebug
+	if s := query.Get("level"); s != "" {
+		level, err := ParseLogLevel(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		minLevel = level
+	}
+	component := query.Get("component")
+
+	events := make([]LogEvent, 0)
+	for _, e := range r.logBroadcaster.History(since) {
+		if e.Level < minLevel || (component != "" && !strings.EqualFold(e.Component, component)) {
+			continue
+		}
+		events = append(events, e)
+	}
+	// Paging forward from since takes the oldest events, otherwise the
+	// newest are the interesting ones
+	if len(events) > limit {
+		if query.Has("since") {
+			events = events[:limit]
+		} else {
+			events = events[len(events)-limit:]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
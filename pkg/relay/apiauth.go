@@ -0,0 +1,156 @@
+package relay
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// API token scopes. Read tokens may only use GET; admin tokens may also
+// kick players and edit the access lists.
+const (
+	APIScopeRead  = "read"
+	APIScopeAdmin = "admin"
+)
+
+// DefaultAPIBind keeps the API off the public interfaces unless asked
+const DefaultAPIBind = "127.0.0.1"
+
+// APIConfig controls who can reach the HTTP API
+type APIConfig struct {
+	// Address to listen on (DefaultAPIBind when empty)
+	Bind string
+
+	// Bearer tokens accepted by the API. With none, every request is
+	// treated as admin.
+	Tokens []APIToken
+
+	// Serve the API over TLS (disabled when TLSCertFile is empty)
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Browser origins, such as "https://dash.example.com", that may open a
+	// WebSocket besides the API's own
+	AllowedOrigins []string
+}
+
+// APIToken is one bearer token and what it may do
+type APIToken struct {
+	Name  string `json:"name,omitempty"` // Who or what the token is for
+	Token string `json:"token"`
+	Scope string `json:"scope"` // APIScopeRead or APIScopeAdmin
+}
+
+// apiTokensFile is the format of the API tokens file
+type apiTokensFile struct {
+	Tokens []APIToken `json:"tokens"`
+}
+
+// LoadAPITokens reads an API tokens file. A missing file has no tokens.
+func LoadAPITokens(path string) ([]APIToken, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file apiTokensFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid API tokens file %s: %w", path, err)
+	}
+	for i, t := range file.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("API token %d in %s is empty", i+1, path)
+		}
+		if t.Scope != APIScopeRead && t.Scope != APIScopeAdmin {
+			return nil, fmt.Errorf("API token %d in %s has scope %q (want read or admin)", i+1, path, t.Scope)
+		}
+	}
+	return file.Tokens, nil
+}
+
+// ClientAPIToken picks the token a local client should present from the
+// tokens file, preferring admin scope
+func ClientAPIToken(tokens []APIToken) string {
+	for _, t := range tokens {
+		if t.Scope == APIScopeAdmin {
+			return t.Token
+		}
+	}
+	if len(tokens) > 0 {
+		return tokens[0].Token
+	}
+	return ""
+}
+
+// APIHost returns the address a local client should dial for an API bound
+// to bind, replacing unspecified addresses with loopback
+func APIHost(bind string, port int) string {
+	if bind == "" {
+		bind = DefaultAPIBind
+	}
+	if ip := net.ParseIP(bind); ip != nil && ip.IsUnspecified() {
+		if ip.To4() != nil {
+			bind = "127.0.0.1"
+		} else {
+			bind = "::1"
+		}
+	}
+	return net.JoinHostPort(bind, fmt.Sprint(port))
+}
+
+// tokenScope returns the scope of token, or "" if it is not accepted
+func (c *APIConfig) tokenScope(token string) string {
+	for _, t := range c.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t.Scope
+		}
+	}
+	return ""
+}
+
+// requestToken returns the bearer token of req. Browsers cannot set
+// headers on a WebSocket or EventSource, so GET requests may pass it as
+// ?access_token= instead.
+func requestToken(req *http.Request) string {
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if req.Method == http.MethodGet {
+		return req.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// requireToken checks the bearer token of every request. Reads need any
+// token, everything else an admin token.
+func (r *Relay) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(r.Config.API.Tokens) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		scope := ""
+		if token := requestToken(req); token != "" {
+			scope = r.Config.API.tokenScope(token)
+		}
+		if scope == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tunnel-relay"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		if scope != APIScopeAdmin && req.Method != http.MethodGet && req.Method != http.MethodHead {
+			writeError(w, http.StatusForbidden, "token is read-only")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,330 @@
+package relay
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"tunnel/pkg/tunnel"
+)
+
+// CertAuthority is a local CA directory that issues per-host client
+// certificates and keeps their revocation list.
+//
+// Layout:
+//
+//	ca.pem, ca-key.pem    CA certificate and key
+//	issued/<serial>.pem   every certificate ever issued
+//	revoked.json          serials that must no longer be accepted
+type CertAuthority struct {
+	Dir string
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	// Cached revocation list, reloaded when the file changes
+	revokedMu    sync.Mutex
+	revoked      map[string]RevokedCert
+	revokedMtime time.Time
+}
+
+// IssuedCert describes a certificate in the CA's issued directory
+type IssuedCert struct {
+	Name      string
+	Serial    string
+	NotAfter  time.Time
+	Revoked   bool
+	RevokedAt time.Time
+}
+
+// RevokedCert is an entry of revoked.json
+type RevokedCert struct {
+	Serial    string    `json:"serial"`
+	Name      string    `json:"name"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+var ErrCertNotFound = errors.New("no matching certificate")
+
+// OpenCA loads the CA in dir, creating it on first use
+func OpenCA(dir string) (*CertAuthority, error) {
+	ca := &CertAuthority{Dir: dir}
+
+	if _, err := os.Stat(ca.certFile()); os.IsNotExist(err) {
+		if err := ca.create(); err != nil {
+			return nil, err
+		}
+	}
+
+	pair, err := loadKeyPair(ca.certFile(), ca.keyFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+	ca.cert, ca.key = pair.cert, pair.key
+	return ca, nil
+}
+
+func (ca *CertAuthority) certFile() string    { return filepath.Join(ca.Dir, "ca.pem") }
+func (ca *CertAuthority) keyFile() string     { return filepath.Join(ca.Dir, "ca-key.pem") }
+func (ca *CertAuthority) issuedDir() string   { return filepath.Join(ca.Dir, "issued") }
+func (ca *CertAuthority) revokedFile() string { return filepath.Join(ca.Dir, "revoked.json") }
+
+func (ca *CertAuthority) create() error {
+	if err := os.MkdirAll(ca.issuedDir(), 0700); err != nil {
+		return fmt.Errorf("failed to create CA directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "tunnel-relay host CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	return tunnel.WriteKeyPair(ca.certFile(), ca.keyFile(), der, key)
+}
+
+// Pool returns a cert pool containing only this CA
+func (ca *CertAuthority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Issue signs a new client certificate for the named host and writes it,
+// with its key, to outDir as <name>.pem and <name>-key.pem
+func (ca *CertAuthority) Issue(name, outDir string) (certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return "", "", err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(5, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	// Keep a record so the certificate can be listed and revoked later
+	if err := os.MkdirAll(ca.issuedDir(), 0700); err != nil {
+		return "", "", err
+	}
+	record := filepath.Join(ca.issuedDir(), serial.Text(16)+".pem")
+	if err := os.WriteFile(record, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to record certificate: %w", err)
+	}
+
+	certFile = filepath.Join(outDir, name+".pem")
+	keyFile = filepath.Join(outDir, name+"-key.pem")
+	if err := tunnel.WriteKeyPair(certFile, keyFile, der, key); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// List returns every issued certificate, oldest first
+func (ca *CertAuthority) List() ([]IssuedCert, error) {
+	entries, err := os.ReadDir(ca.issuedDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	revoked, err := ca.readRevoked()
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []IssuedCert
+	notBefore := make(map[string]time.Time)
+	for _, e := range entries {
+		cert, err := readCert(filepath.Join(ca.issuedDir(), e.Name()))
+		if err != nil {
+			continue
+		}
+		serial := cert.SerialNumber.Text(16)
+		entry := IssuedCert{
+			Name:     cert.Subject.CommonName,
+			Serial:   serial,
+			NotAfter: cert.NotAfter,
+		}
+		if r, ok := revoked[serial]; ok {
+			entry.Revoked = true
+			entry.RevokedAt = r.RevokedAt
+		}
+		notBefore[serial] = cert.NotBefore
+		certs = append(certs, entry)
+	}
+
+	sort.Slice(certs, func(i, j int) bool {
+		a, b := notBefore[certs[i].Serial], notBefore[certs[j].Serial]
+		if a.Equal(b) {
+			return certs[i].Name < certs[j].Name
+		}
+		return a.Before(b)
+	})
+	return certs, nil
+}
+
+// Revoke revokes every certificate whose name or serial matches nameOrSerial
+func (ca *CertAuthority) Revoke(nameOrSerial string) ([]IssuedCert, error) {
+	certs, err := ca.List()
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := ca.readRevoked()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []IssuedCert
+	for _, c := range certs {
+		if c.Revoked || (c.Name != nameOrSerial && c.Serial != nameOrSerial) {
+			continue
+		}
+		revoked[c.Serial] = RevokedCert{Serial: c.Serial, Name: c.Name, RevokedAt: time.Now()}
+		matched = append(matched, c)
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCertNotFound, nameOrSerial)
+	}
+
+	list := make([]RevokedCert, 0, len(revoked))
+	for _, r := range revoked {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RevokedAt.Before(list[j].RevokedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(ca.revokedFile(), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write revocation list: %w", err)
+	}
+	return matched, nil
+}
+
+// IsRevoked reports whether cert is on the revocation list. The list is
+// re-read whenever the file changes so revocations apply without a restart.
+func (ca *CertAuthority) IsRevoked(cert *x509.Certificate) bool {
+	ca.revokedMu.Lock()
+	defer ca.revokedMu.Unlock()
+
+	info, err := os.Stat(ca.revokedFile())
+	if err == nil && !info.ModTime().Equal(ca.revokedMtime) {
+		if revoked, err := ca.readRevoked(); err == nil {
+			ca.revoked = revoked
+			ca.revokedMtime = info.ModTime()
+		}
+	}
+
+	_, revoked := ca.revoked[cert.SerialNumber.Text(16)]
+	return revoked
+}
+
+func (ca *CertAuthority) readRevoked() (map[string]RevokedCert, error) {
+	revoked := make(map[string]RevokedCert)
+
+	data, err := os.ReadFile(ca.revokedFile())
+	if os.IsNotExist(err) {
+		return revoked, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+
+	var list []RevokedCert
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse revocation list: %w", err)
+	}
+	for _, r := range list {
+		revoked[r.Serial] = r
+	}
+	return revoked, nil
+}
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func loadKeyPair(certFile, keyFile string) (*keyPair, error) {
+	cert, err := readCert(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no key found in %s", keyFile)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{cert: cert, key: key}, nil
+}
+
+func readCert(file string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	return serial, nil
+}
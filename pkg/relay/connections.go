@@ -0,0 +1,320 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic counts payload bytes to and from players, and datagrams for
+// Bedrock. Bedrock's length prefixes inside the tunnel are not counted.
+type Traffic struct {
+	BytesIn    int64 `json:"bytes_in"`              // From players
+	BytesOut   int64 `json:"bytes_out"`             // To players
+	PacketsIn  int64 `json:"packets_in,omitempty"`  // Bedrock datagrams from players
+	PacketsOut int64 `json:"packets_out,omitempty"` // Bedrock datagrams to players
+}
+
+// add counts bytes and packets moving from (in) or to a player
+func (t *Traffic) add(in bool, bytes, packets int) {
+	if in {
+		atomic.AddInt64(&t.BytesIn, int64(bytes))
+		atomic.AddInt64(&t.PacketsIn, int64(packets))
+	} else {
+		atomic.AddInt64(&t.BytesOut, int64(bytes))
+		atomic.AddInt64(&t.PacketsOut, int64(packets))
+	}
+}
+
+func (t *Traffic) snapshot() Traffic {
+	return Traffic{
+		BytesIn:    atomic.LoadInt64(&t.BytesIn),
+		BytesOut:   atomic.LoadInt64(&t.BytesOut),
+		PacketsIn:  atomic.LoadInt64(&t.PacketsIn),
+		PacketsOut: atomic.LoadInt64(&t.PacketsOut),
+	}
+}
+
+// connection is one player the relay is carrying to a host
+type connection struct {
+	id         uint64
+	protocol   string // "tcp" for Java, "udp" for Bedrock
+	remoteAddr net.Addr
+	hostname   string     // Server address from the handshake, "" for Bedrock
+	login      loginStart // Java player identity, empty for Bedrock
+	host       *hostSession
+	since      time.Time
+
+	traffic    Traffic
+	total      *Traffic // Relay-wide counters for the connection's protocol
+	lastActive int64    // Unix nanoseconds of the last byte either way
+
+	// kick closes the player and the stream, telling the player reason
+	// where the protocol still allows it
+	kick     func(reason string)
+	kickOnce sync.Once
+}
+
+// label names the player in log lines
+func (c *connection) label() string {
+	return playerLabel(c.remoteAddr, c.login)
+}
+
+// fields describes the connection in log events
+func (c *connection) fields() Fields {
+	fields := Fields{"conn_id": c.id, "remote_addr": c.remoteAddr.String(), "host_id": c.host.id}
+	if c.login.Name != "" {
+		fields["player"] = c.login.Name
+	}
+	if c.login.UUID != "" {
+		fields["uuid"] = c.login.UUID
+	}
+	return fields
+}
+
+// disconnectFields adds the connection's traffic and duration to fields()
+func (c *connection) disconnectFields() Fields {
+	fields := c.fields()
+	traffic := c.traffic.snapshot()
+	fields["bytes_in"] = traffic.BytesIn
+	fields["bytes_out"] = traffic.BytesOut
+	if c.protocol == "udp" {
+		fields["packets_in"] = traffic.PacketsIn
+		fields["packets_out"] = traffic.PacketsOut
+	}
+	fields["duration"] = time.Since(c.since).Round(time.Second).String()
+	return fields
+}
+
+// component is the log prefix for the connection's protocol
+func (c *connection) component() string {
+	if c.protocol == "udp" {
+		return "Bedrock"
+	}
+	return "Game"
+}
+
+// count records traffic from (in) or to the player on the connection,
+// its host and the relay
+func (c *connection) count(in bool, bytes, packets int) {
+	c.traffic.add(in, bytes, packets)
+	c.host.traffic.add(in, bytes, packets)
+	c.total.add(in, bytes, packets)
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+// reader counts everything read from r as traffic from (in) or to the player
+func (c *connection) reader(r io.Reader, in bool) io.Reader {
+	return &connReader{r: r, conn: c, in: in}
+}
+
+type connReader struct {
+	r    io.Reader
+	conn *connection
+	in   bool
+}
+
+func (c *connReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.conn.count(c.in, n, 0)
+	}
+	return n, err
+}
+
+// loginWriter serializes writes to a Java player. Until the host writes
+// anything, the connection is still in the uncompressed, unencrypted
+// login state and a Login Disconnect can be sent in its place.
+type loginWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	started bool // The host has written to the player
+	closed  bool
+}
+
+func (l *loginWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, net.ErrClosed
+	}
+	l.started = true
+	return l.w.Write(p)
+}
+
+// disconnect stops further writes, first sending a Login Disconnect
+// showing reason if the host has not answered the login yet
+func (l *loginWriter) disconnect(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.started && !l.closed {
+		writeLoginDisconnect(l.w, reason)
+	}
+	l.closed = true
+}
+
+// connRegistry tracks every player connection by ID
+type connRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*connection
+}
+
+// add assigns c an ID and registers it
+func (r *connRegistry) add(c *connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[uint64]*connection)
+	}
+	r.nextID++
+	c.id = r.nextID
+	c.since = time.Now()
+	c.lastActive = c.since.UnixNano()
+	r.conns[c.id] = c
+}
+
+func (r *connRegistry) remove(c *connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, c.id)
+}
+
+func (r *connRegistry) get(id uint64) *connection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[id]
+}
+
+// fromIP returns the connections whose player address is ip
+func (r *connRegistry) fromIP(ip net.IP) []*connection {
+	var conns []*connection
+	for _, c := range r.snapshot() {
+		if addrIP(c.remoteAddr).Equal(ip) {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+func (r *connRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// snapshot returns the connections oldest first
+func (r *connRegistry) snapshot() []*connection {
+	r.mu.Lock()
+	conns := make([]*connection, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// ConnectionStatus describes one player in GET /connections
+type ConnectionStatus struct {
+	ID             uint64 `json:"id"`
+	Protocol       string `json:"protocol"` // "tcp" (Java) or "udp" (Bedrock)
+	RemoteAddr     string `json:"remote_addr"`
+	Hostname       string `json:"hostname,omitempty"`
+	Username       string `json:"username,omitempty"`
+	UUID           string `json:"uuid,omitempty"`
+	HostID         uint64 `json:"host_id"`
+	Host           string `json:"host"`
+	ConnectedSince int64  `json:"connected_since"` // Unix seconds
+	LastActivity   int64  `json:"last_activity"`   // Unix seconds
+	Traffic
+}
+
+// DefaultKickMessage is shown to kicked Java players when no reason is given
+const DefaultKickMessage = "Disconnected by an operator"
+
+// kick disconnects a player. Java players still logging in see reason.
+func (r *Relay) kick(c *connection, reason string) {
+	if reason == "" {
+		reason = DefaultKickMessage
+	}
+	c.kickOnce.Do(func() {
+		r.logf(LevelInfo, c.component(), c.fields(), "Kicking %s: %s", c.label(), reason)
+		c.kick(reason)
+	})
+}
+
+func connectionStatuses(conns []*connection) []ConnectionStatus {
+	statuses := make([]ConnectionStatus, 0, len(conns))
+	for _, c := range conns {
+		statuses = append(statuses, ConnectionStatus{
+			ID:             c.id,
+			Protocol:       c.protocol,
+			RemoteAddr:     c.remoteAddr.String(),
+			Hostname:       c.hostname,
+			Username:       c.login.Name,
+			UUID:           c.login.UUID,
+			HostID:         c.host.id,
+			Host:           c.host.name(),
+			ConnectedSince: c.since.Unix(),
+			LastActivity:   time.Unix(0, atomic.LoadInt64(&c.lastActive)).Unix(),
+			Traffic:        c.traffic.snapshot(),
+		})
+	}
+	return statuses
+}
+
+// handleConnections lists players (GET) or kicks every player from one
+// address (DELETE ?ip=&reason=)
+func (r *Relay) handleConnections(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(connectionStatuses(r.connections.snapshot()))
+	case http.MethodDelete:
+		ip := net.ParseIP(req.URL.Query().Get("ip"))
+		if ip == nil {
+			writeError(w, http.StatusBadRequest, "missing or invalid ip parameter")
+			return
+		}
+		r.kickAll(w, r.connections.fromIP(ip), req.URL.Query().Get("reason"))
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleKick kicks one player (DELETE /connections/{id}?reason=)
+func (r *Relay) handleKick(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseUint(req.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid connection id")
+		return
+	}
+	var conns []*connection
+	if c := r.connections.get(id); c != nil {
+		conns = append(conns, c)
+	}
+	r.kickAll(w, conns, req.URL.Query().Get("reason"))
+}
+
+// kickAll kicks conns and answers with what was kicked
+func (r *Relay) kickAll(w http.ResponseWriter, conns []*connection, reason string) {
+	if len(conns) == 0 {
+		writeError(w, http.StatusNotFound, "no matching connection")
+		return
+	}
+	statuses := connectionStatuses(conns)
+	for _, c := range conns {
+		r.kick(c, reason)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
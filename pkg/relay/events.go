@@ -0,0 +1,297 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on /events
+const (
+	EventPlayerConnected    = "player_connected"
+	EventPlayerDisconnected = "player_disconnected"
+	EventHostConnected      = "host_connected"
+	EventHostDisconnected   = "host_disconnected"
+	EventRateLimited        = "rate_limited"
+	EventACLDenied          = "acl_denied"
+	EventTunnelDown         = "tunnel_down"     // No primary host for Config.TunnelDownAfter
+	EventTunnelRestored     = "tunnel_restored" // A host returned after EventTunnelDown
+)
+
+var eventTypes = []string{
+	EventPlayerConnected,
+	EventPlayerDisconnected,
+	EventHostConnected,
+	EventHostDisconnected,
+	EventRateLimited,
+	EventACLDenied,
+	EventTunnelDown,
+	EventTunnelRestored,
+}
+
+// Event is something that happened on the relay, for programs that would
+// otherwise parse log lines. Fields that do not apply to the type are
+// omitted.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Players
+	Edition         string  `json:"edition,omitempty"` // "java" or "bedrock"
+	ConnID          uint64  `json:"conn_id,omitempty"`
+	RemoteAddr      string  `json:"remote_addr,omitempty"` // Player address, or the host's for host events
+	Player          string  `json:"player,omitempty"`
+	UUID            string  `json:"uuid,omitempty"`
+	Hostname        string  `json:"hostname,omitempty"` // Server address the Java player asked for
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	*Traffic                // player_disconnected only
+
+	// Hosts
+	HostID          uint64   `json:"host_id,omitempty"`
+	Identity        string   `json:"identity,omitempty"`
+	Role            string   `json:"role,omitempty"` // "primary", "standby" or "active"
+	Hostnames       []string `json:"hostnames,omitempty"`
+	TunnelConnected *bool    `json:"tunnel_connected,omitempty"` // Host events: whether a primary host remains
+
+	// Rejections
+	Reason     string `json:"reason,omitempty"`
+	Suppressed int    `json:"suppressed,omitempty"` // Rejections since the last reported one that got no event
+
+	// Tunnel outages
+	DownSeconds float64 `json:"down_seconds,omitempty"`
+}
+
+// eventBus fans events out to subscribers, dropping events for
+// subscribers that fall behind
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func (b *eventBus) subscribe(buffer int) chan Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]bool)
+	}
+	ch := make(chan Event, buffer)
+	b.subscribers[ch] = true
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// emit publishes e, stamping it with the current time
+func (r *Relay) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r.events.publish(e)
+}
+
+// edition names the Minecraft edition behind a log component or protocol
+func edition(componentOrProtocol string) string {
+	switch componentOrProtocol {
+	case "Bedrock", "udp":
+		return "bedrock"
+	}
+	return "java"
+}
+
+// event describes the connection for a player event. Disconnects carry
+// the connection's traffic and duration.
+func (c *connection) event(eventType string) Event {
+	e := Event{
+		Type:       eventType,
+		Edition:    edition(c.protocol),
+		ConnID:     c.id,
+		RemoteAddr: c.remoteAddr.String(),
+		Player:     c.login.Name,
+		UUID:       c.login.UUID,
+		Hostname:   c.hostname,
+		HostID:     c.host.id,
+		Identity:   c.host.identity,
+	}
+	if eventType == EventPlayerDisconnected {
+		traffic := c.traffic.snapshot()
+		e.Traffic = &traffic
+		e.DurationSeconds = time.Since(c.since).Round(time.Millisecond).Seconds()
+	}
+	return e
+}
+
+// hostEvent describes a host joining or leaving in the given role
+func (r *Relay) hostEvent(eventType string, h *hostSession, role string) Event {
+	connected := r.hosts.primary() != nil
+	return Event{
+		Type:            eventType,
+		RemoteAddr:      h.remoteAddr,
+		HostID:          h.id,
+		Identity:        h.identity,
+		Role:            role,
+		Hostnames:       h.hostnames,
+		TunnelConnected: &connected,
+	}
+}
+
+// watchTunnel publishes EventTunnelDown once no primary host has been
+// connected for Config.TunnelDownAfter, counting from startup, and
+// EventTunnelRestored when a host comes back after that
+func (r *Relay) watchTunnel() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	downSince := time.Now()
+	reported := false
+	for range ticker.C {
+		up := r.hosts.primary() != nil
+		switch {
+		case up && !downSince.IsZero():
+			if reported {
+				down := time.Since(downSince).Round(time.Second)
+				r.logf(LevelInfo, "Control", nil, "Tunnel restored after %s", down)
+				r.emit(Event{Type: EventTunnelRestored, DownSeconds: down.Seconds()})
+			}
+			downSince, reported = time.Time{}, false
+		case !up && downSince.IsZero():
+			downSince = time.Now()
+		case !up && !reported && time.Since(downSince) >= r.Config.TunnelDownAfter:
+			down := time.Since(downSince).Round(time.Second)
+			r.logf(LevelWarn, "Control", nil, "Tunnel down for %s", down)
+			r.emit(Event{Type: EventTunnelDown, DownSeconds: down.Seconds()})
+			reported = true
+		}
+	}
+}
+
+// parseEventTypes reads a comma-separated ?type= filter. An empty filter
+// matches every type.
+func parseEventTypes(s string) (map[string]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	types := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if !slices.Contains(eventTypes, t) {
+			return nil, fmt.Errorf("unknown event type %q (want %s)", t, strings.Join(eventTypes, ", "))
+		}
+		types[t] = true
+	}
+	return types, nil
+}
+
+// handleEvents streams events as JSON, over a WebSocket when the client
+// asks for one and as Server-Sent Events otherwise. ?type= limits the
+// stream to a comma-separated list of event types.
+func (r *Relay) handleEvents(w http.ResponseWriter, req *http.Request) {
+	types, err := parseEventTypes(req.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isWebSocketUpgrade(req) {
+		r.serveEventsWebSocket(w, req, types)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := r.events.subscribe(100)
+	defer r.events.unsubscribe(ch)
+
+	// A comment sends the headers before the first event
+	fmt.Fprint(w, ": connected\n\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if types != nil && !types[e.Type] {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// serveEventsWebSocket sends each event as one text message until the
+// client closes the socket
+func (r *Relay) serveEventsWebSocket(w http.ResponseWriter, req *http.Request, types map[string]bool) {
+	ws, err := upgradeWebSocket(w, req, r.Config.API.AllowedOrigins)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	ch := r.events.subscribe(100)
+	defer r.events.unsubscribe(ch)
+
+	// The client sends nothing we need, but reading answers its pings and
+	// notices when it goes away
+	closed := make(chan struct{})
+	go func() {
+		ws.readLoop()
+		close(closed)
+	}()
+
+	ping := time.NewTicker(websocketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if types != nil && !types[e.Type] {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			if err := ws.writeFrame(wsOpText, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := ws.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
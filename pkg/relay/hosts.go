@@ -0,0 +1,557 @@
+package relay
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tunnel/pkg/tunnel"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Host policies decide what happens when a host connects while another one
+// is already serving players
+const (
+	HostPolicyReplace = "replace" // Close the current host and use the newcomer
+	HostPolicyReject  = "reject"  // Refuse newcomers while a healthy host exists
+	HostPolicyStandby = "standby" // Keep newcomers as ranked standbys for failover
+	HostPolicyBalance = "balance" // Spread players across every connected host
+)
+
+// Balancing strategies used with HostPolicyBalance
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceLeastConnections = "least-connections"
+	BalanceWeighted         = "weighted"
+)
+
+const (
+	healthInterval = 10 * time.Second
+	maxMissedPings = 3
+)
+
+var (
+	errHostRejected = errors.New("relay already has an active host")
+	errNoHost       = errors.New("no host connected")
+)
+
+// hostSession is an authenticated host and its yamux session
+type hostSession struct {
+	id            uint64
+	session       *yamux.Session
+	identity      string // Client certificate CN, if any
+	remoteAddr    string
+	clientVersion string
+	features      []string // Negotiated in the hello exchange
+	hostnames     []string // Normalized and sorted, empty for a catch-all host
+	priority      int      // Higher ranks first among standbys
+	weight        int      // Share of players under BalanceWeighted
+	connectedAt   time.Time
+
+	rtt     int64   // Last keepalive round trip in nanoseconds
+	players int64   // Players currently served by this host
+	traffic Traffic // Carried for players over this session
+
+	currentWeight int // Smooth weighted round-robin state, guarded by hostPool.mu
+}
+
+// fields describes the host in log events
+func (h *hostSession) fields() Fields {
+	fields := Fields{"host_id": h.id, "remote_addr": h.remoteAddr}
+	if h.identity != "" {
+		fields["identity"] = h.identity
+	}
+	return fields
+}
+
+// release marks one of the host's players as gone
+func (h *hostSession) release() {
+	atomic.AddInt64(&h.players, -1)
+}
+
+// group identifies the hosts that compete under the host policy: those
+// announcing the same hostnames
+func (h *hostSession) group() string {
+	return strings.Join(h.hostnames, ",")
+}
+
+// serves reports whether the host announced hostname, either exactly or
+// through a "*.example.com" wildcard
+func (h *hostSession) serves(hostname string) bool {
+	for _, pattern := range h.hostnames {
+		if pattern == hostname {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(hostname, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHostnames lowercases, deduplicates and sorts the hostnames a
+// host announces so equal sets compare equal
+func normalizeHostnames(hostnames []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, h := range hostnames {
+		h = normalizeHostname(h)
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		out = append(out, h)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// name identifies the host in logs
+func (h *hostSession) name() string {
+	if h.identity != "" {
+		return fmt.Sprintf("#%d %s", h.id, h.identity)
+	}
+	return fmt.Sprintf("#%d %s", h.id, h.remoteAddr)
+}
+
+// hostPool tracks connected hosts. Hosts announcing the same hostnames form
+// a group: the first host of a group is the primary that serves its
+// players, the rest are standbys ordered by rank.
+type hostPool struct {
+	mu     sync.Mutex
+	hosts  []*hostSession
+	nextID uint64
+	next   int // Round-robin cursor
+}
+
+// primary returns the host currently serving players, or nil
+func (p *hostPool) primary() *hostSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		if !h.session.IsClosed() {
+			return h
+		}
+	}
+	return nil
+}
+
+// live returns the number of hosts whose session is still open
+func (p *hostPool) live() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, h := range p.hosts {
+		if !h.session.IsClosed() {
+			n++
+		}
+	}
+	return n
+}
+
+// withFeature returns the first live host without a hostname restriction
+// that negotiated feature, or nil
+func (p *hostPool) withFeature(feature string) *hostSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.routable("") {
+		if tunnel.HasFeature(h.features, feature) {
+			return h
+		}
+	}
+	return nil
+}
+
+// hasHealthyPrimary reports whether a live host is serving players for
+// the given group
+func (p *hostPool) hasHealthyPrimary(group string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	members := p.members(group)
+	return len(members) > 0 && !p.hosts[members[0]].session.IsClosed()
+}
+
+// members returns the indexes of the group's hosts in rank order
+func (p *hostPool) members(group string) []int {
+	var idx []int
+	for i, h := range p.hosts {
+		if h.group() == group {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// insertFirst makes h the primary of its group
+func (p *hostPool) insertFirst(h *hostSession) {
+	members := p.members(h.group())
+	if len(members) == 0 {
+		p.hosts = append(p.hosts, h)
+		return
+	}
+	i := members[0]
+	p.hosts = append(p.hosts[:i], append([]*hostSession{h}, p.hosts[i:]...)...)
+}
+
+// add installs a host according to policy and reports the role it got
+func (p *hostPool) add(h *hostSession, policy string) (primary bool, replaced []*hostSession, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextID++
+	h.id = p.nextID
+
+	group := h.group()
+	members := p.members(group)
+	healthy := len(members) > 0 && !p.hosts[members[0]].session.IsClosed()
+
+	switch policy {
+	case HostPolicyBalance:
+		p.hosts = append(p.hosts, h)
+		return !healthy, nil, nil
+	case HostPolicyReplace:
+		kept := p.hosts[:0]
+		for _, x := range p.hosts {
+			if x.group() == group {
+				replaced = append(replaced, x)
+			} else {
+				kept = append(kept, x)
+			}
+		}
+		p.hosts = append(kept, h)
+		return true, replaced, nil
+	case HostPolicyReject:
+		if healthy {
+			return false, nil, errHostRejected
+		}
+		p.insertFirst(h)
+		return true, nil, nil
+	default:
+		if !healthy {
+			p.insertFirst(h)
+			return true, nil, nil
+		}
+		p.hosts = append(p.hosts, h)
+		p.rankStandbys(group)
+		return false, nil, nil
+	}
+}
+
+// remove drops a host and reports the new primary of its group if the
+// primary changed. found is false for a host already dropped, such as one
+// another host replaced.
+func (p *hostPool) remove(h *hostSession) (found, wasPrimary bool, promoted *hostSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	members := p.members(h.group())
+	for n, i := range members {
+		if p.hosts[i] != h {
+			continue
+		}
+		p.hosts = append(p.hosts[:i], p.hosts[i+1:]...)
+		if n != 0 {
+			return true, false, nil
+		}
+		if len(members) > 1 {
+			promoted = p.hosts[members[1]-1]
+		}
+		return true, true, promoted
+	}
+	return false, false, nil
+}
+
+// rankStandbys orders a group's standbys by priority, then by connection
+// time. The primary keeps its place so a returning host never preempts it.
+func (p *hostPool) rankStandbys(group string) {
+	members := p.members(group)
+	if len(members) < 3 {
+		return
+	}
+	standbys := make([]*hostSession, 0, len(members)-1)
+	for _, i := range members[1:] {
+		standbys = append(standbys, p.hosts[i])
+	}
+	sort.SliceStable(standbys, func(i, j int) bool {
+		if standbys[i].priority != standbys[j].priority {
+			return standbys[i].priority > standbys[j].priority
+		}
+		return standbys[i].connectedAt.Before(standbys[j].connectedAt)
+	})
+	for n, i := range members[1:] {
+		p.hosts[i] = standbys[n]
+	}
+}
+
+// routable returns the live hosts that may serve hostname: those that
+// announced it, else the catch-all hosts. Players without a hostname
+// (Bedrock, legacy pings) fall back to any host.
+func (p *hostPool) routable(hostname string) []*hostSession {
+	var matched, catchAll, live []*hostSession
+	for _, h := range p.hosts {
+		if h.session.IsClosed() {
+			continue
+		}
+		live = append(live, h)
+		if len(h.hostnames) == 0 {
+			catchAll = append(catchAll, h)
+		} else if hostname != "" && h.serves(hostname) {
+			matched = append(matched, h)
+		}
+	}
+	switch {
+	case len(matched) > 0:
+		return matched
+	case len(catchAll) > 0:
+		return catchAll
+	case hostname == "":
+		return live
+	}
+	return nil
+}
+
+// candidates returns the hosts for hostname in the order a new player
+// should try them. Outside of HostPolicyBalance that is simply the
+// failover order.
+func (p *hostPool) candidates(policy, strategy, hostname string) []*hostSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	live := p.routable(hostname)
+	if policy != HostPolicyBalance || len(live) < 2 {
+		return live
+	}
+
+	switch strategy {
+	case BalanceLeastConnections:
+		p.rotate(live)
+		sort.SliceStable(live, func(i, j int) bool {
+			return atomic.LoadInt64(&live[i].players) < atomic.LoadInt64(&live[j].players)
+		})
+	case BalanceWeighted:
+		// Smooth weighted round-robin (as in nginx): the chosen host goes
+		// first, the rest follow as fallbacks
+		total, best := 0, 0
+		for i, h := range live {
+			h.currentWeight += h.weight
+			total += h.weight
+			if h.currentWeight > live[best].currentWeight {
+				best = i
+			}
+		}
+		live[best].currentWeight -= total
+		live[0], live[best] = live[best], live[0]
+	default:
+		p.rotate(live)
+	}
+	return live
+}
+
+// rotate shifts hosts left by the round-robin cursor and advances it
+func (p *hostPool) rotate(hosts []*hostSession) {
+	n := p.next % len(hosts)
+	p.next++
+	rotated := append(append([]*hostSession(nil), hosts[n:]...), hosts[:n]...)
+	copy(hosts, rotated)
+}
+
+// snapshot returns the hosts in rank order
+func (p *hostPool) snapshot() []*hostSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*hostSession(nil), p.hosts...)
+}
+
+// HostStatus describes a connected host in StatusResponse
+type HostStatus struct {
+	ID             uint64   `json:"id"`
+	Role           string   `json:"role"` // "primary", "standby" or "active"
+	Identity       string   `json:"identity,omitempty"`
+	Hostnames      []string `json:"hostnames,omitempty"`
+	RemoteAddr     string   `json:"remote_addr"`
+	Version        string   `json:"version,omitempty"`
+	Priority       int      `json:"priority"`
+	Weight         int      `json:"weight"`
+	Players        int64    `json:"players"`
+	ConnectedSince int64    `json:"connected_since"` // Unix seconds
+	RTTMillis      float64  `json:"rtt_ms"`
+	Traffic
+}
+
+func (r *Relay) hostStatuses() []HostStatus {
+	hosts := r.hosts.snapshot()
+	statuses := make([]HostStatus, 0, len(hosts))
+	seen := make(map[string]bool)
+	for _, h := range hosts {
+		role := "standby"
+		if r.Config.HostPolicy == HostPolicyBalance {
+			role = "active"
+		} else if !seen[h.group()] {
+			role = "primary"
+		}
+		seen[h.group()] = true
+		statuses = append(statuses, HostStatus{
+			ID:             h.id,
+			Role:           role,
+			Identity:       h.identity,
+			Hostnames:      h.hostnames,
+			RemoteAddr:     h.remoteAddr,
+			Version:        h.clientVersion,
+			Priority:       h.priority,
+			Weight:         h.weight,
+			Players:        atomic.LoadInt64(&h.players),
+			ConnectedSince: h.connectedAt.Unix(),
+			RTTMillis:      float64(atomic.LoadInt64(&h.rtt)) / float64(time.Millisecond),
+			Traffic:        h.traffic.snapshot(),
+		})
+	}
+	return statuses
+}
+
+// addHost registers an established host session and watches it until it
+// goes away, promoting the next standby if it was the primary
+func (r *Relay) addHost(h *hostSession, cert *x509.Certificate) {
+	primary, replaced, err := r.hosts.add(h, r.Config.HostPolicy)
+	if err != nil {
+		r.logf(LevelWarn, "Control", nil, "Rejected host %s: %v", h.remoteAddr, err)
+		h.session.Close()
+		return
+	}
+
+	atomic.AddInt64(&r.hostConnects, 1)
+
+	// Replaced hosts are out of the pool by the time their sessions close,
+	// so removeHost no longer knows their role. The first was the primary.
+	for i, old := range replaced {
+		role := "standby"
+		if i == 0 {
+			role = "primary"
+		}
+		r.logf(LevelInfo, "Control", old.fields(), "Replacing %s host %s with %s", role, old.name(), h.name())
+		old.session.Close()
+		r.emit(r.hostEvent(EventHostDisconnected, old, role))
+	}
+
+	if r.Config.HostPolicy == HostPolicyBalance {
+		r.logf(LevelInfo, "Control", h.fields(), "Tunnel established with host %s (weight %d)", h.name(), h.weight)
+		r.emit(r.hostEvent(EventHostConnected, h, "active"))
+	} else if primary {
+		r.logf(LevelInfo, "Control", h.fields(), "Tunnel established with host %s (primary)", h.name())
+		r.emit(r.hostEvent(EventHostConnected, h, "primary"))
+	} else {
+		r.logf(LevelInfo, "Control", h.fields(), "Host %s connected as standby (priority %d)", h.name(), h.priority)
+		r.emit(r.hostEvent(EventHostConnected, h, "standby"))
+	}
+
+	if cert != nil {
+		go r.watchRevocation(h.session, cert)
+	}
+	go r.monitorHost(h)
+}
+
+// monitorHost pings a host to measure RTT and closes the session after
+// repeated missed keepalives, then removes it from the pool
+func (r *Relay) monitorHost(h *hostSession) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	if rtt, err := h.session.Ping(); err == nil {
+		atomic.StoreInt64(&h.rtt, int64(rtt))
+	}
+
+	missed := 0
+	for {
+		select {
+		case <-h.session.CloseChan():
+			r.removeHost(h)
+			return
+		case <-ticker.C:
+			rtt, err := h.session.Ping()
+			if err != nil {
+				missed++
+				if missed >= maxMissedPings {
+					r.logf(LevelWarn, "Control", h.fields(), "Host %s missed %d keepalives, closing", h.name(), missed)
+					h.session.Close()
+				}
+				continue
+			}
+			missed = 0
+			atomic.StoreInt64(&h.rtt, int64(rtt))
+		}
+	}
+}
+
+// openStream opens a player stream on the host chosen for hostname by the
+// policy, falling back to the next candidate when a session refuses the
+// stream. The caller must release the returned host when the player leaves.
+func (r *Relay) openStream(hostname string) (*hostSession, net.Conn, error) {
+	candidates := r.hosts.candidates(r.Config.HostPolicy, r.Config.BalanceStrategy, hostname)
+	if len(candidates) == 0 {
+		return nil, nil, errNoHost
+	}
+
+	var lastErr error
+	for _, h := range candidates {
+		stream, err := h.session.Open()
+		if err != nil {
+			r.logf(LevelWarn, "Control", h.fields(), "Host %s failed to open stream: %v", h.name(), err)
+			atomic.AddInt64(&r.streamFailures, 1)
+			lastErr = err
+			continue
+		}
+		atomic.AddInt64(&h.players, 1)
+		return h, stream, nil
+	}
+	return nil, nil, lastErr
+}
+
+func (r *Relay) removeHost(h *hostSession) {
+	found, wasPrimary, promoted := r.hosts.remove(h)
+	if !found {
+		// Replaced, and addHost already reported it
+		return
+	}
+	if r.Config.HostPolicy == HostPolicyBalance {
+		r.logf(LevelInfo, "Control", h.fields(), "Host %s disconnected (%d remaining)", h.name(), r.hosts.live())
+		r.emit(r.hostEvent(EventHostDisconnected, h, "active"))
+		return
+	}
+	if !wasPrimary {
+		r.logf(LevelInfo, "Control", h.fields(), "Host %s disconnected", h.name())
+		r.emit(r.hostEvent(EventHostDisconnected, h, "standby"))
+		return
+	}
+	r.emit(r.hostEvent(EventHostDisconnected, h, "primary"))
+	if promoted != nil {
+		r.logf(LevelWarn, "Control", h.fields(), "Primary host %s lost, promoting standby %s", h.name(), promoted.name())
+		return
+	}
+	r.logf(LevelWarn, "Control", h.fields(), "Tunnel lost (host %s disconnected)", h.name())
+}
+
+// watchRevocation drops a host's session if its certificate is revoked
+// while it is connected
+func (r *Relay) watchRevocation(session *yamux.Session, cert *x509.Certificate) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-session.CloseChan():
+			return
+		case <-ticker.C:
+			if r.ca != nil && r.ca.IsRevoked(cert) {
+				r.logf(LevelWarn, "Control", nil, "Certificate of %s revoked, closing session", cert.Subject.CommonName)
+				session.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bounds how fast and how many player connections the relay lets
+// through to hosts. Zero values disable the corresponding limit.
+type Limits struct {
+	IPRate      float64 // New connections per second from one IP
+	IPBurst     int
+	SubnetRate  float64 // New connections per second from one /24 (IPv4) or /64 (IPv6)
+	SubnetBurst int
+	MaxPerIP    int // Concurrent connections from one IP
+	MaxTotal    int // Concurrent connections overall
+}
+
+// DefaultLimits are generous enough for players behind a shared NAT
+func DefaultLimits() Limits {
+	return Limits{
+		IPRate:      2,
+		IPBurst:     10,
+		SubnetRate:  10,
+		SubnetBurst: 50,
+		MaxPerIP:    10,
+		MaxTotal:    1000,
+	}
+}
+
+// RejectionCounts counts connections refused by each limit
+type RejectionCounts struct {
+	IPRate          int64 `json:"ip_rate"`
+	SubnetRate      int64 `json:"subnet_rate"`
+	IPConcurrent    int64 `json:"ip_concurrent"`
+	TotalConcurrent int64 `json:"total_concurrent"`
+	ACL             int64 `json:"acl"` // Refused by the allow/deny lists or a ban
+}
+
+const (
+	// limitLogInterval is the least time between two rejection logs
+	limitLogInterval = 10 * time.Second
+
+	// bucketPruneInterval is how often idle token buckets are dropped
+	bucketPruneInterval = time.Minute
+)
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// limiter enforces Limits and tracks active connections per IP
+type limiter struct {
+	limits Limits
+
+	mu        sync.Mutex
+	ips       map[string]*tokenBucket
+	subnets   map[string]*tokenBucket
+	active    map[string]int
+	total     int
+	lastPrune time.Time
+
+	rejected RejectionCounts
+}
+
+func newLimiter(limits Limits) *limiter {
+	if limits.IPRate > 0 && limits.IPBurst < 1 {
+		limits.IPBurst = 1
+	}
+	if limits.SubnetRate > 0 && limits.SubnetBurst < 1 {
+		limits.SubnetBurst = 1
+	}
+	return &limiter{
+		limits:  limits,
+		ips:     make(map[string]*tokenBucket),
+		subnets: make(map[string]*tokenBucket),
+		active:  make(map[string]int),
+	}
+}
+
+// subnetKey groups an address with its /24 or /64 neighbours
+func subnetKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// bucket returns the key's bucket, creating a full one
+func bucket(m map[string]*tokenBucket, key string, now time.Time, burst int) *tokenBucket {
+	b, ok := m[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		m[key] = b
+	}
+	return b
+}
+
+// acquire admits a new connection from ip. On success the caller must call
+// release when the connection ends; otherwise reason names the limit hit.
+func (l *limiter) acquire(ip net.IP) (release func(), reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	key := ip.String()
+
+	if l.limits.MaxTotal > 0 && l.total >= l.limits.MaxTotal {
+		atomic.AddInt64(&l.rejected.TotalConcurrent, 1)
+		return nil, "too many connections"
+	}
+	if l.limits.MaxPerIP > 0 && l.active[key] >= l.limits.MaxPerIP {
+		atomic.AddInt64(&l.rejected.IPConcurrent, 1)
+		return nil, "too many connections from this IP"
+	}
+
+	// Check both buckets before taking from either
+	var ipBucket, subnetBucket *tokenBucket
+	if l.limits.IPRate > 0 {
+		ipBucket = bucket(l.ips, key, now, l.limits.IPBurst)
+		ipBucket.refill(now, l.limits.IPRate, l.limits.IPBurst)
+		if ipBucket.tokens < 1 {
+			atomic.AddInt64(&l.rejected.IPRate, 1)
+			return nil, "per-IP rate limit"
+		}
+	}
+	if l.limits.SubnetRate > 0 {
+		subnetBucket = bucket(l.subnets, subnetKey(ip), now, l.limits.SubnetBurst)
+		subnetBucket.refill(now, l.limits.SubnetRate, l.limits.SubnetBurst)
+		if subnetBucket.tokens < 1 {
+			atomic.AddInt64(&l.rejected.SubnetRate, 1)
+			return nil, "per-subnet rate limit"
+		}
+	}
+	if ipBucket != nil {
+		ipBucket.tokens--
+	}
+	if subnetBucket != nil {
+		subnetBucket.tokens--
+	}
+
+	l.active[key]++
+	l.total++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.total--
+			if l.active[key]--; l.active[key] <= 0 {
+				delete(l.active, key)
+			}
+		})
+	}, ""
+}
+
+// prune drops buckets that have refilled completely, since they behave
+// exactly like new ones. Called with l.mu held.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < bucketPruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.ips {
+		if b.refill(now, l.limits.IPRate, l.limits.IPBurst); b.tokens >= float64(l.limits.IPBurst) {
+			delete(l.ips, key)
+		}
+	}
+	for key, b := range l.subnets {
+		if b.refill(now, l.limits.SubnetRate, l.limits.SubnetBurst); b.tokens >= float64(l.limits.SubnetBurst) {
+			delete(l.subnets, key)
+		}
+	}
+}
+
+// counts returns a snapshot of the rejection counters
+func (l *limiter) counts() RejectionCounts {
+	return RejectionCounts{
+		IPRate:          atomic.LoadInt64(&l.rejected.IPRate),
+		SubnetRate:      atomic.LoadInt64(&l.rejected.SubnetRate),
+		IPConcurrent:    atomic.LoadInt64(&l.rejected.IPConcurrent),
+		TotalConcurrent: atomic.LoadInt64(&l.rejected.TotalConcurrent),
+	}
+}
+
+// logThrottle lets one rejection log through per limitLogInterval
+type logThrottle struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// allow reports whether a rejection may be logged now, and how many were
+// suppressed since the last one that was
+func (t *logThrottle) allow() (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.last) < limitLogInterval {
+		t.suppressed++
+		return false, 0
+	}
+	suppressed := t.suppressed
+	t.last, t.suppressed = time.Now(), 0
+	return true, suppressed
+}
+
+// logRejection logs a refused player and publishes eventType for it,
+// noting how many refusals the throttle dropped since the last one
+func (r *Relay) logRejection(eventType, component string, addr net.Addr, reason string, suppressed int) {
+	msg := fmt.Sprintf("Rejected %s: %s", addr, reason)
+	fields := Fields{"remote_addr": addr.String(), "reason": reason}
+	if suppressed > 0 {
+		msg += fmt.Sprintf(" (%d more rejections not logged)", suppressed)
+		fields["suppressed"] = suppressed
+	}
+	r.logf(LevelWarn, component, fields, "%s", msg)
+	r.emit(Event{Type: eventType, Edition: edition(component), RemoteAddr: addr.String(), Reason: reason, Suppressed: suppressed})
+}
+
+// addrIP returns the IP of a TCP or UDP address, nil for anything else
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// admit applies the connection limits to a new player from addr and logs
+// rejections at a reduced rate. It returns nil if the player is refused.
+func (r *Relay) admit(component string, addr net.Addr) func() {
+	ip := addrIP(addr)
+	if ip == nil {
+		return func() {}
+	}
+
+	release, reason := r.limits.acquire(ip)
+	if release != nil {
+		return release
+	}
+
+	if ok, suppressed := r.rejectLog.allow(); ok {
+		r.logRejection(EventRateLimited, component, addr, reason, suppressed)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		rate    float64
+		burst   int
+		want    float64
+	}{
+		{"no time passed", 0, 0, 2, 10, 0},
+		{"partial refill", 0, 1500 * time.Millisecond, 2, 10, 3},
+		{"capped at burst", 4, time.Hour, 2, 10, 10},
+		{"already full", 10, time.Second, 2, 10, 10},
+		{"slow rate", 0, 10 * time.Second, 0.1, 5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tokenBucket{tokens: tt.tokens, last: start}
+			now := start.Add(tt.elapsed)
+			b.refill(now, tt.rate, tt.burst)
+			if diff := b.tokens - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.want)
+			}
+			if !b.last.Equal(now) {
+				t.Errorf("last = %v, want %v", b.last, now)
+			}
+		})
+	}
+}
+
+func TestSubnetKey(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.7", "203.0.113.0/24"},
+		{"203.0.113.250", "203.0.113.0/24"},
+		{"::ffff:203.0.113.7", "203.0.113.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+	}
+	for _, tt := range tests {
+		if got := subnetKey(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("subnetKey(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// acquireN admits n connections from ip, returning the release functions
+// and the first rejection reason
+func acquireN(l *limiter, ip string, n int) ([]func(), string) {
+	var releases []func()
+	for range n {
+		release, reason := l.acquire(net.ParseIP(ip))
+		if release == nil {
+			return releases, reason
+		}
+		releases = append(releases, release)
+	}
+	return releases, ""
+}
+
+func TestLimiterRateBurst(t *testing.T) {
+	// A rate this low never refills during the test
+	l := newLimiter(Limits{IPRate: 0.001, IPBurst: 3})
+
+	releases, reason := acquireN(l, "203.0.113.7", 4)
+	if len(releases) != 3 || reason != "per-IP rate limit" {
+		t.Fatalf("admitted %d (%q), want 3 then the per-IP rate limit", len(releases), reason)
+	}
+	// Closing connections does not return rate tokens
+	for _, release := range releases {
+		release()
+	}
+	if release, _ := l.acquire(net.ParseIP("203.0.113.7")); release != nil {
+		t.Error("admitted after the burst was spent")
+	}
+	// Other addresses have their own bucket
+	if release, reason := l.acquire(net.ParseIP("198.51.100.1")); release == nil {
+		t.Errorf("other IP rejected: %s", reason)
+	}
+	if got := l.counts().IPRate; got != 2 {
+		t.Errorf("counted %d per-IP rate rejections, want 2", got)
+	}
+}
+
+func TestLimiterSubnetRate(t *testing.T) {
+	l := newLimiter(Limits{SubnetRate: 0.001, SubnetBurst: 2})
+
+	if _, reason := acquireN(l, "203.0.113.1", 1); reason != "" {
+		t.Fatalf("first address rejected: %s", reason)
+	}
+	if _, reason := acquireN(l, "203.0.113.2", 1); reason != "" {
+		t.Fatalf("second address rejected: %s", reason)
+	}
+	if _, reason := acquireN(l, "203.0.113.3", 1); reason != "per-subnet rate limit" {
+		t.Errorf("third address in the /24: %q, want the per-subnet rate limit", reason)
+	}
+	if _, reason := acquireN(l, "203.0.114.1", 1); reason != "" {
+		t.Errorf("neighbouring /24 rejected: %s", reason)
+	}
+}
+
+func TestLimiterRejectedRateTakesNoTokens(t *testing.T) {
+	// The subnet bucket runs out first; the IP bucket must keep its tokens
+	l := newLimiter(Limits{IPRate: 0.001, IPBurst: 2, SubnetRate: 0.001, SubnetBurst: 1})
+
+	if _, reason := acquireN(l, "203.0.113.1", 1); reason != "" {
+		t.Fatalf("rejected: %s", reason)
+	}
+	if _, reason := acquireN(l, "203.0.113.1", 1); reason != "per-subnet rate limit" {
+		t.Fatalf("got %q, want the per-subnet rate limit", reason)
+	}
+	if tokens := l.ips["203.0.113.1"].tokens; tokens < 0.99 {
+		t.Errorf("IP bucket has %v tokens after a subnet rejection, want 1", tokens)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := newLimiter(Limits{MaxPerIP: 2, MaxTotal: 3})
+
+	releases, reason := acquireN(l, "203.0.113.7", 3)
+	if len(releases) != 2 || reason != "too many connections from this IP" {
+		t.Fatalf("admitted %d (%q), want 2 then the per-IP cap", len(releases), reason)
+	}
+	if _, reason := acquireN(l, "198.51.100.1", 1); reason != "" {
+		t.Fatalf("rejected: %s", reason)
+	}
+	if _, reason := acquireN(l, "192.0.2.1", 1); reason != "too many connections" {
+		t.Errorf("got %q, want the total cap", reason)
+	}
+
+	// Releasing frees a slot, and releasing twice frees only one
+	releases[0]()
+	releases[0]()
+	if _, reason := acquireN(l, "192.0.2.1", 1); reason != "" {
+		t.Errorf("rejected after a release: %s", reason)
+	}
+	if _, reason := acquireN(l, "192.0.2.2", 1); reason != "too many connections" {
+		t.Errorf("got %q after a double release, want the total cap", reason)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := newLimiter(Limits{})
+	if releases, reason := acquireN(l, "203.0.113.7", 100); len(releases) != 100 {
+		t.Errorf("admitted %d of 100 with no limits: %s", len(releases), reason)
+	}
+}
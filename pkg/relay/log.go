@@ -0,0 +1,316 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel orders log events by severity. The zero value is LevelInfo.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLogLevel parses debug, info, warn (or warning) and error
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	level, err := ParseLogLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Log file formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Fields carries the structured details of a log event, such as
+// remote_addr, conn_id, player, host or bytes_in
+type Fields map[string]any
+
+// LogEvent is one entry of the relay log
+type LogEvent struct {
+	ID        uint64    `json:"id,omitempty"` // Increases by one per event, 0 for events outside the log
+	Time      time.Time `json:"time"`
+	Level     LogLevel  `json:"level"`
+	Component string    `json:"component,omitempty"` // Control, Game, Bedrock, ACL or API; empty for the relay itself
+	Message   string    `json:"message"`
+	Fields    Fields    `json:"fields,omitempty"`
+}
+
+// Line renders the event as "[Component] message", the form logs took
+// before they carried structure
+func (e LogEvent) Line() string {
+	if e.Component == "" {
+		return e.Message
+	}
+	return "[" + e.Component + "] " + e.Message
+}
+
+// Text renders the event as one line of the text log format
+func (e LogEvent) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", e.Time.Format(time.RFC3339), strings.ToUpper(e.Level.String()), e.Line())
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := fmt.Sprint(e.Fields[k])
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, value)
+	}
+	return b.String()
+}
+
+// Log records an info event from a "[Component] message" line
+func (r *Relay) Log(msg string) {
+	component, message := "", msg
+	if rest, ok := strings.CutPrefix(msg, "["); ok {
+		if c, m, ok := strings.Cut(rest, "] "); ok {
+			component, message = c, m
+		}
+	}
+	r.logEvent(LogEvent{Level: LevelInfo, Component: component, Message: message})
+}
+
+// logf records an event from component with optional fields
+func (r *Relay) logf(level LogLevel, component string, fields Fields, format string, args ...any) {
+	r.logEvent(LogEvent{Level: level, Component: component, Message: fmt.Sprintf(format, args...), Fields: fields})
+}
+
+// logEvent writes e to the log output and subscribers unless it is below
+// the configured level
+func (r *Relay) logEvent(e LogEvent) {
+	if e.Level < r.Config.LogLevel {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	e = r.logBroadcaster.Broadcast(e)
+
+	if r.Config.LogOutput != nil {
+		var line []byte
+		if r.Config.LogFormat == LogFormatJSON {
+			line, _ = json.Marshal(e)
+		} else {
+			line = []byte(e.Text())
+		}
+		r.logMu.Lock()
+		r.Config.LogOutput.Write(append(line, '\n'))
+		r.logMu.Unlock()
+	}
+}
+
+// DefaultLogHistory is how many log events are kept for replay
+const DefaultLogHistory = 1000
+
+// LogBroadcaster numbers log events, keeps the most recent ones for
+// replay and fans them out to subscribers
+type LogBroadcaster struct {
+	subscribers []*LogSubscription
+	mu          sync.Mutex
+
+	history []LogEvent // Ring buffer, oldest at start once full
+	start   int
+	lastID  uint64
+	nextSub uint64
+}
+
+func NewLogBroadcaster(history int) *LogBroadcaster {
+	if history <= 0 {
+		history = DefaultLogHistory
+	}
+	return &LogBroadcaster{
+		subscribers: make([]*LogSubscription, 0),
+		history:     make([]LogEvent, 0, history),
+	}
+}
+
+// LogSubscription receives log events on C. Events that arrive while C
+// is full are dropped and counted.
+type LogSubscription struct {
+	C          chan LogEvent
+	id         uint64
+	remoteAddr string
+	since      time.Time
+	dropped    int64 // Total since subscribing
+	unreported int64 // Dropped since the subscriber last asked
+}
+
+// TakeDropped returns how many events were dropped since the last call
+func (s *LogSubscription) TakeDropped() int64 {
+	return atomic.SwapInt64(&s.unreported, 0)
+}
+
+// LogSubscriberStatus describes a log subscriber in StatusResponse
+type LogSubscriberStatus struct {
+	ID             uint64 `json:"id"`
+	RemoteAddr     string `json:"remote_addr"`
+	ConnectedSince int64  `json:"connected_since"` // Unix seconds
+	Dropped        int64  `json:"dropped"`
+}
+
+// Subscribe registers a subscriber for remoteAddr and returns it with the
+// kept events after afterID (all of them for 0). Nothing is missed or
+// repeated between the two. An afterID the relay has not reached yet
+// comes from before a restart and also gets everything.
+func (b *LogBroadcaster) Subscribe(remoteAddr string, afterID uint64) (*LogSubscription, []LogEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if afterID > b.lastID {
+		afterID = 0
+	}
+	b.nextSub++
+	sub := &LogSubscription{C: make(chan LogEvent, 100), id: b.nextSub, remoteAddr: remoteAddr, since: time.Now()}
+	b.subscribers = append(b.subscribers, sub)
+	return sub, b.after(afterID)
+}
+
+func (b *LogBroadcaster) Unsubscribe(sub *LogSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, s := range b.subscribers {
+		if s == sub {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			close(sub.C)
+			break
+		}
+	}
+}
+
+// Broadcast numbers e, keeps it and sends it to every subscriber,
+// returning it with its ID
+func (b *LogBroadcaster) Broadcast(e LogEvent) LogEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, e)
+	} else {
+		b.history[b.start] = e
+		b.start = (b.start + 1) % len(b.history)
+	}
+
+	for _, sub := range b.subscribers {
+		select {
+		case sub.C <- e:
+		default:
+			// Drop message if channel is full to prevent blocking
+			atomic.AddInt64(&sub.dropped, 1)
+			atomic.AddInt64(&sub.unreported, 1)
+		}
+	}
+	return e
+}
+
+// History returns kept events after afterID, oldest first
+func (b *LogBroadcaster) History(afterID uint64) []LogEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.after(afterID)
+}
+
+// after copies the kept events with IDs above id. Callers hold b.mu.
+func (b *LogBroadcaster) after(id uint64) []LogEvent {
+	var events []LogEvent
+	for i := range b.history {
+		e := b.history[(b.start+i)%len(b.history)]
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Subscribers describes the current subscribers
+func (b *LogBroadcaster) Subscribers() []LogSubscriberStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	statuses := make([]LogSubscriberStatus, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		statuses = append(statuses, LogSubscriberStatus{
+			ID:             sub.id,
+			RemoteAddr:     sub.remoteAddr,
+			ConnectedSince: sub.since.Unix(),
+			Dropped:        atomic.LoadInt64(&sub.dropped),
+		})
+	}
+	return statuses
+}
+
+// writeSSE sends e as a Server-Sent Event named after its level, with the
+// event as JSON data, or as its plain line when text is set. The event ID
+// lets clients resume with Last-Event-ID.
+func writeSSE(w io.Writer, e LogEvent, text bool) {
+	if e.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", e.ID)
+	}
+	if text {
+		fmt.Fprintf(w, "data: %s\n\n", e.Line())
+		return
+	}
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Level, data)
+}
+
+// writeDropped tells a subscriber that n events did not fit its buffer
+func writeDropped(w io.Writer, n int64, text bool) {
+	if text {
+		fmt.Fprintf(w, "data: [API] %d log events dropped, the client is reading too slowly\n\n", n)
+		return
+	}
+	fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", n)
+}
@@ -0,0 +1,121 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus metrics in the text exposition format, written by hand so the
+// relay needs no client library.
+
+// metricSample is one line of a metric family
+type metricSample struct {
+	labels []string // Alternating label names and values
+	value  float64
+}
+
+func sample(value float64, labels ...string) metricSample {
+	return metricSample{labels: labels, value: value}
+}
+
+// writeMetric writes a metric family with its HELP and TYPE lines
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(s.labels), strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// trafficSamples splits t into samples by direction, labelled with protocol
+func trafficSamples(protocol string, bytes bool, t Traffic) []metricSample {
+	in, out := t.PacketsIn, t.PacketsOut
+	if bytes {
+		in, out = t.BytesIn, t.BytesOut
+	}
+	return []metricSample{
+		sample(float64(in), "protocol", protocol, "direction", "in"),
+		sample(float64(out), "protocol", protocol, "direction", "out"),
+	}
+}
+
+// handleMetrics serves GET /metrics for Prometheus
+func (r *Relay) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	var java, bedrock int
+	for _, c := range r.connections.snapshot() {
+		if c.protocol == "udp" {
+			bedrock++
+		} else {
+			java++
+		}
+	}
+	javaTraffic, bedrockTraffic := r.javaTraffic.snapshot(), r.bedrockTraffic.snapshot()
+	rejected := r.rejectionCounts()
+	hosts := r.hostStatuses()
+
+	var rtts, players []metricSample
+	for _, h := range hosts {
+		id := strconv.FormatUint(h.ID, 10)
+		rtts = append(rtts, sample(h.RTTMillis/1000, "host_id", id, "identity", h.Identity, "role", h.Role))
+		players = append(players, sample(float64(h.Players), "host_id", id, "identity", h.Identity, "role", h.Role))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "relay_active_players", "gauge", "Players currently carried to a host.",
+		sample(float64(java), "protocol", "java"),
+		sample(float64(bedrock), "protocol", "bedrock"))
+	writeMetric(w, "relay_traffic_bytes_total", "counter", "Payload bytes from (in) and to (out) players.",
+		append(trafficSamples("java", true, javaTraffic), trafficSamples("bedrock", true, bedrockTraffic)...)...)
+	writeMetric(w, "relay_traffic_packets_total", "counter", "Bedrock datagrams from (in) and to (out) players.",
+		trafficSamples("bedrock", false, bedrockTraffic)...)
+	writeMetric(w, "relay_connections_accepted_total", "counter", "Player connections that passed the ACL and connection limits.",
+		sample(float64(atomic.LoadInt64(&r.javaAccepted)), "protocol", "java"),
+		sample(float64(atomic.LoadInt64(&r.bedrockAccepted)), "protocol", "bedrock"))
+	writeMetric(w, "relay_connections_rejected_total", "counter", "Player connections refused by reason.",
+		sample(float64(rejected.IPRate), "reason", "ip_rate"),
+		sample(float64(rejected.SubnetRate), "reason", "subnet_rate"),
+		sample(float64(rejected.IPConcurrent), "reason", "ip_concurrent"),
+		sample(float64(rejected.TotalConcurrent), "reason", "total_concurrent"),
+		sample(float64(rejected.ACL), "reason", "acl"))
+	writeMetric(w, "relay_stream_open_failures_total", "counter", "Player streams a host session failed to open.",
+		sample(float64(atomic.LoadInt64(&r.streamFailures))))
+	writeMetric(w, "relay_tunnel_connected", "gauge", "Whether a host is connected (1) or not (0).",
+		sample(boolValue(r.hosts.primary() != nil)))
+	writeMetric(w, "relay_hosts", "gauge", "Connected host sessions, including standbys.",
+		sample(float64(len(hosts))))
+	writeMetric(w, "relay_host_connects_total", "counter", "Host sessions established, including standbys and balanced hosts.",
+		sample(float64(atomic.LoadInt64(&r.hostConnects))))
+	writeMetric(w, "relay_host_rtt_seconds", "gauge", "Last yamux keepalive round trip per host session.", rtts...)
+	writeMetric(w, "relay_host_players", "gauge", "Players currently served per host session.", players...)
+	writeMetric(w, "relay_bedrock_sessions", "gauge", "Open Bedrock sessions.",
+		sample(float64(r.BedrockSessions())))
+	writeMetric(w, "relay_bedrock_sessions_ended_total", "counter", "Bedrock sessions that ended, by cause.",
+		sample(float64(atomic.LoadInt64(&r.BedrockReaped)), "cause", "idle"),
+		sample(float64(atomic.LoadInt64(&r.BedrockClosed)), "cause", "closed"))
+	writeMetric(w, "relay_uptime_seconds", "gauge", "Seconds since the relay started.",
+		sample(time.Since(r.StartTime).Seconds()))
+}
@@ -0,0 +1,334 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Minecraft Java Edition protocol helpers used to route players before any
+// bytes reach a host.
+
+const (
+	// maxHandshakeSize bounds the first packet. Proxies that forward player
+	// data in the server address (BungeeCord IP forwarding) make it larger
+	// than the vanilla limit.
+	maxHandshakeSize = 64 * 1024
+
+	// maxStatusPacketSize bounds packets read while answering a status ping
+	maxStatusPacketSize = 1024
+
+	// maxLoginStartSize bounds Login Start, which carries a public key and
+	// signature on 1.19 to 1.19.2
+	maxLoginStartSize = 8 * 1024
+)
+
+// Protocol versions that changed Login Start
+const (
+	protocol1_19   = 759 // Adds signature data and an optional UUID
+	protocol1_19_3 = 761 // Drops the signature data
+	protocol1_20_2 = 764 // The UUID is always sent
+)
+
+// Handshake next states
+const (
+	stateStatus   = 1
+	stateLogin    = 2
+	stateTransfer = 3
+)
+
+var errBadVarInt = errors.New("VarInt too long")
+
+// handshake is the first packet a Java client sends
+type handshake struct {
+	ProtocolVersion int32
+	ServerAddress   string // Normalized hostname the player typed, "" if unknown
+	ServerPort      uint16
+	NextState       int32
+}
+
+// loginStart is the player identity a Java client sends on login
+type loginStart struct {
+	Name string
+	UUID string // Dashed lowercase hex, "" on clients before 1.19
+}
+
+// readVarInt reads a protocol VarInt one byte at a time so nothing past the
+// value is consumed
+func readVarInt(r io.Reader) (int32, []byte, error) {
+	var value uint32
+	var raw []byte
+	b := make([]byte, 1)
+	for i := 0; i < 5; i++ {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, raw, err
+		}
+		raw = append(raw, b[0])
+		value |= uint32(b[0]&0x7F) << (7 * i)
+		if b[0]&0x80 == 0 {
+			return int32(value), raw, nil
+		}
+	}
+	return 0, raw, errBadVarInt
+}
+
+func appendVarInt(buf []byte, v int32) []byte {
+	u := uint32(v)
+	for u >= 0x80 {
+		buf = append(buf, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(buf, byte(u))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendVarInt(buf, int32(len(s)))
+	return append(buf, s...)
+}
+
+// readString reads a VarInt-prefixed string from an in-memory packet
+func readString(r *bytes.Reader) (string, error) {
+	n, _, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || int(n) > r.Len() {
+		return "", fmt.Errorf("string length %d out of range", n)
+	}
+	s := make([]byte, n)
+	io.ReadFull(r, s)
+	return string(s), nil
+}
+
+// readPacket reads one uncompressed packet and splits off its ID
+func readPacket(r io.Reader, limit int) (int32, []byte, error) {
+	length, _, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length <= 0 || int(length) > limit {
+		return 0, nil, fmt.Errorf("packet length %d out of range", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	br := bytes.NewReader(data)
+	id, _, err := readVarInt(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, data[len(data)-br.Len():], nil
+}
+
+// writePacket writes one uncompressed packet
+func writePacket(w io.Writer, id int32, payload []byte) error {
+	body := appendVarInt(nil, id)
+	body = append(body, payload...)
+	_, err := w.Write(append(appendVarInt(nil, int32(len(body))), body...))
+	return err
+}
+
+// peekHandshake reads the client's handshake and returns it with the raw
+// bytes consumed, which must be replayed to the host. Legacy (pre-1.7)
+// pings and unparseable packets come back with an empty ServerAddress.
+func peekHandshake(conn net.Conn) (handshake, []byte, error) {
+	var hs handshake
+
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		return hs, nil, err
+	}
+	if first[0] == 0xFE {
+		// Legacy server list ping, which has no server address
+		hs.NextState = stateStatus
+		return hs, first, nil
+	}
+
+	length, raw, err := readVarInt(io.MultiReader(bytes.NewReader(first), conn))
+	if err != nil {
+		return hs, raw, err
+	}
+	if length <= 0 || length > maxHandshakeSize {
+		return hs, raw, fmt.Errorf("handshake length %d out of range", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return hs, raw, err
+	}
+	raw = append(raw, data...)
+
+	br := bytes.NewReader(data)
+	if id, _, err := readVarInt(br); err != nil || id != 0x00 {
+		return hs, raw, nil
+	}
+	if hs.ProtocolVersion, _, err = readVarInt(br); err != nil {
+		return handshake{}, raw, nil
+	}
+	addr, err := readString(br)
+	if err != nil {
+		return handshake{}, raw, nil
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return handshake{}, raw, nil
+	}
+	hs.ServerPort = uint16(port[0])<<8 | uint16(port[1])
+	if hs.NextState, _, err = readVarInt(br); err != nil {
+		return handshake{}, raw, nil
+	}
+	hs.ServerAddress = normalizeHostname(addr)
+	return hs, raw, nil
+}
+
+// peekLoginStart reads the Login Start packet that follows a login
+// handshake and returns it with the raw bytes consumed. An unexpected
+// packet comes back with an empty Name.
+func peekLoginStart(conn net.Conn, protocolVersion int32) (loginStart, []byte, error) {
+	var login loginStart
+
+	length, raw, err := readVarInt(conn)
+	if err != nil {
+		return login, raw, err
+	}
+	if length <= 0 || length > maxLoginStartSize {
+		return login, raw, fmt.Errorf("login start length %d out of range", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return login, raw, err
+	}
+	raw = append(raw, data...)
+
+	br := bytes.NewReader(data)
+	if id, _, err := readVarInt(br); err != nil || id != 0x00 {
+		return login, raw, nil
+	}
+	if login.Name, err = readString(br); err != nil {
+		return loginStart{}, raw, nil
+	}
+	login.UUID = readLoginUUID(br, protocolVersion)
+	return login, raw, nil
+}
+
+// readLoginUUID reads the UUID after the name in Login Start, whose
+// layout depends on the protocol version. It returns "" when there is none.
+func readLoginUUID(br *bytes.Reader, protocolVersion int32) string {
+	switch {
+	case protocolVersion >= protocol1_20_2:
+	case protocolVersion >= protocol1_19_3:
+		if has, err := br.ReadByte(); err != nil || has == 0 {
+			return ""
+		}
+	case protocolVersion >= protocol1_19:
+		// Skip the optional chat signing key: expiry, public key, signature
+		if has, err := br.ReadByte(); err != nil {
+			return ""
+		} else if has != 0 {
+			if _, err := br.Seek(8, io.SeekCurrent); err != nil {
+				return ""
+			}
+			for i := 0; i < 2; i++ {
+				n, _, err := readVarInt(br)
+				if err != nil || n < 0 || int(n) > br.Len() {
+					return ""
+				}
+				br.Seek(int64(n), io.SeekCurrent)
+			}
+		}
+		if has, err := br.ReadByte(); err != nil || has == 0 {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(br, id); err != nil {
+		return ""
+	}
+	h := hex.EncodeToString(id)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// normalizeHostname strips what mod loaders and proxies append to the
+// server address (Forge's "\x00FML\x00", BungeeCord forwarding data, a
+// trailing dot) and lowercases the rest
+func normalizeHostname(addr string) string {
+	if i := strings.IndexByte(addr, 0); i >= 0 {
+		addr = addr[:i]
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(addr), "."))
+}
+
+// textComponent encodes msg as a JSON chat component
+func textComponent(msg string) string {
+	data, _ := json.Marshal(map[string]string{"text": msg})
+	return string(data)
+}
+
+// writeLoginDisconnect sends a Login Disconnect packet with msg as the reason
+func writeLoginDisconnect(w io.Writer, msg string) error {
+	return writePacket(w, 0x00, appendString(nil, textComponent(msg)))
+}
+
+// serveStatus answers a status request with the given JSON response and
+// echoes the client's ping so the server list shows a latency
+func serveStatus(conn net.Conn, response string) error {
+	id, _, err := readPacket(conn, maxStatusPacketSize)
+	if err != nil {
+		return err
+	}
+	if id != 0x00 {
+		return fmt.Errorf("unexpected packet 0x%02x in status state", id)
+	}
+	if err := writePacket(conn, 0x00, appendString(nil, response)); err != nil {
+		return err
+	}
+
+	id, payload, err := readPacket(conn, maxStatusPacketSize)
+	if err != nil {
+		// Clients may close without pinging
+		return nil
+	}
+	if id == 0x01 {
+		return writePacket(conn, 0x01, payload)
+	}
+	return nil
+}
+
+// statusResponse builds a minimal server list entry showing motd
+func statusResponse(protocolVersion int32, motd string) string {
+	data, _ := json.Marshal(map[string]any{
+		"version":     map[string]any{"name": "tunnel-relay", "protocol": protocolVersion},
+		"players":     map[string]any{"max": 0, "online": 0},
+		"description": map[string]string{"text": motd},
+	})
+	return string(data)
+}
+
+// rejectPlayer answers a Java client that cannot reach a host: pings get
+// the status response, logins a disconnect screen showing kick
+func rejectPlayer(conn net.Conn, hs handshake, status, kick string) error {
+	conn.SetDeadline(time.Now().Add(playerHandshakeTimeout))
+	switch hs.NextState {
+	case stateLogin, stateTransfer:
+		return writeLoginDisconnect(conn, kick)
+	case stateStatus:
+		if hs.ProtocolVersion == 0 {
+			return nil // Legacy ping, nothing useful to say
+		}
+		return serveStatus(conn, status)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// readerConn is a net.Conn whose reads come from r. Only Read is usable.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readerConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// connOf returns a connection that reads data and then io.EOF
+func connOf(data []byte) readerConn {
+	return readerConn{r: bytes.NewReader(data)}
+}
+
+// packet frames id and payload as an uncompressed packet
+func packet(id int32, payload []byte) []byte {
+	body := append(appendVarInt(nil, id), payload...)
+	return append(appendVarInt(nil, int32(len(body))), body...)
+}
+
+// handshakePacket builds a handshake for address and next state
+func handshakePacket(protocol int32, address string, port uint16, next int32) []byte {
+	payload := appendVarInt(nil, protocol)
+	payload = appendString(payload, address)
+	payload = append(payload, byte(port>>8), byte(port))
+	payload = appendVarInt(payload, next)
+	return packet(0x00, payload)
+}
+
+func TestReadVarInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int32
+		wantLen int // Bytes consumed
+		wantErr error
+	}{
+		{"zero", []byte{0x00}, 0, 1, nil},
+		{"one byte", []byte{0x7f}, 127, 1, nil},
+		{"two bytes", []byte{0x80, 0x01}, 128, 2, nil},
+		{"game port", []byte{0xdd, 0xc7, 0x01}, 25565, 3, nil},
+		{"max int32", []byte{0xff, 0xff, 0xff, 0xff, 0x07}, 2147483647, 5, nil},
+		{"negative", []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, -1, 5, nil},
+		{"stops at the value", []byte{0x01, 0x02, 0x03}, 1, 1, nil},
+		{"empty", nil, 0, 0, io.EOF},
+		{"truncated", []byte{0x80}, 0, 1, io.EOF},
+		{"truncated after four bytes", []byte{0xff, 0xff, 0xff, 0xff}, 0, 4, io.EOF},
+		{"too long", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, 0, 5, errBadVarInt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, raw, err := readVarInt(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("value = %d, want %d", got, tt.want)
+			}
+			if len(raw) != tt.wantLen {
+				t.Errorf("consumed %d bytes, want %d", len(raw), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestVarIntRoundTrip(t *testing.T) {
+	for _, v := range []int32{0, 1, 127, 128, 255, 25565, 2097151, 2147483647, -1, -2147483648} {
+		got, raw, err := readVarInt(bytes.NewReader(appendVarInt(nil, v)))
+		if err != nil || got != v {
+			t.Errorf("round trip of %d = %d, %v", v, got, err)
+		}
+		if len(raw) > 5 {
+			t.Errorf("%d encoded in %d bytes", v, len(raw))
+		}
+	}
+}
+
+func TestReadString(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"empty", []byte{0x00}, "", false},
+		{"ascii", appendString(nil, "mc.example.com"), "mc.example.com", false},
+		{"utf-8", appendString(nil, "서버"), "서버", false},
+		{"longer than the packet", []byte{0x05, 'a', 'b'}, "", true},
+		{"negative length", []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, "", true},
+		{"huge length", []byte{0xff, 0xff, 0xff, 0xff, 0x07, 'a'}, "", true},
+		{"truncated length", []byte{0x80}, "", true},
+		{"no data", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readString(bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		limit       int
+		wantID      int32
+		wantPayload []byte
+		wantErr     bool
+	}{
+		{"status request", packet(0x00, nil), 16, 0x00, []byte{}, false},
+		{"ping", packet(0x01, []byte{1, 2, 3, 4, 5, 6, 7, 8}), 16, 0x01, []byte{1, 2, 3, 4, 5, 6, 7, 8}, false},
+		{"at the limit", packet(0x00, make([]byte, 15)), 16, 0x00, make([]byte, 15), false},
+		{"over the limit", packet(0x00, make([]byte, 16)), 16, 0, nil, true},
+		{"zero length", []byte{0x00}, 16, 0, nil, true},
+		{"negative length", []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 16, 0, nil, true},
+		{"truncated body", []byte{0x05, 0x00, 0x01}, 16, 0, nil, true},
+		{"truncated length", []byte{0x80}, 16, 0, nil, true},
+		{"bad packet ID", []byte{0x02, 0x80, 0x80}, 16, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, payload, err := readPacket(bytes.NewReader(tt.data), tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if id != tt.wantID || !bytes.Equal(payload, tt.wantPayload) {
+				t.Errorf("got 0x%02x %x, want 0x%02x %x", id, payload, tt.wantID, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestPeekHandshake(t *testing.T) {
+	login := handshakePacket(767, "mc.example.com", 25565, stateLogin)
+	oversized := append(appendVarInt(nil, maxHandshakeSize+1), make([]byte, 16)...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    handshake
+		wantRaw int // Bytes consumed, which must be replayed to the host
+		wantErr bool
+	}{
+		{
+			name:    "login",
+			data:    login,
+			want:    handshake{ProtocolVersion: 767, ServerAddress: "mc.example.com", ServerPort: 25565, NextState: stateLogin},
+			wantRaw: len(login),
+		},
+		{
+			name:    "status",
+			data:    handshakePacket(47, "play.example.com", 25565, stateStatus),
+			want:    handshake{ProtocolVersion: 47, ServerAddress: "play.example.com", ServerPort: 25565, NextState: stateStatus},
+			wantRaw: len(handshakePacket(47, "play.example.com", 25565, stateStatus)),
+		},
+		{
+			name:    "leaves the next packet unread",
+			data:    append(append([]byte{}, login...), packet(0x00, appendString(nil, "Steve"))...),
+			want:    handshake{ProtocolVersion: 767, ServerAddress: "mc.example.com", ServerPort: 25565, NextState: stateLogin},
+			wantRaw: len(login),
+		},
+		{
+			name:    "Forge marker and trailing dot",
+			data:    handshakePacket(767, "MC.Example.com.\x00FML3\x00", 25565, stateLogin),
+			want:    handshake{ProtocolVersion: 767, ServerAddress: "mc.example.com", ServerPort: 25565, NextState: stateLogin},
+			wantRaw: len(handshakePacket(767, "MC.Example.com.\x00FML3\x00", 25565, stateLogin)),
+		},
+		{
+			name:    "legacy ping",
+			data:    []byte{0xfe, 0x01, 0xfa},
+			want:    handshake{NextState: stateStatus},
+			wantRaw: 1,
+		},
+		{
+			name:    "other packet",
+			data:    packet(0x05, []byte{1, 2, 3}),
+			wantRaw: len(packet(0x05, []byte{1, 2, 3})),
+		},
+		{
+			name:    "address longer than the packet",
+			data:    packet(0x00, append(appendVarInt(nil, 767), 0x7f, 'a')),
+			wantRaw: len(packet(0x00, append(appendVarInt(nil, 767), 0x7f, 'a'))),
+		},
+		{
+			name:    "missing port",
+			data:    packet(0x00, appendString(appendVarInt(nil, 767), "mc.example.com")),
+			wantRaw: len(packet(0x00, appendString(appendVarInt(nil, 767), "mc.example.com"))),
+		},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "zero length", data: []byte{0x00}, wantRaw: 1, wantErr: true},
+		{name: "oversized", data: oversized, wantRaw: 3, wantErr: true},
+		{name: "truncated length", data: []byte{0x80}, wantRaw: 1, wantErr: true},
+		{name: "length too long", data: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, wantRaw: 5, wantErr: true},
+		{name: "truncated body", data: login[:len(login)-3], wantRaw: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs, raw, err := peekHandshake(connOf(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if hs != tt.want {
+				t.Errorf("handshake = %+v, want %+v", hs, tt.want)
+			}
+			if len(raw) != tt.wantRaw {
+				t.Errorf("consumed %d bytes, want %d", len(raw), tt.wantRaw)
+			}
+			if !bytes.HasPrefix(tt.data, raw) {
+				t.Errorf("raw bytes %x are not what was read from %x", raw, tt.data)
+			}
+		})
+	}
+}
+
+func TestNormalizeHostname(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"mc.example.com", "mc.example.com"},
+		{"MC.Example.COM", "mc.example.com"},
+		{"mc.example.com.", "mc.example.com"},
+		{"mc.example.com:25565", "mc.example.com"},
+		{" mc.example.com ", "mc.example.com"},
+		{"mc.example.com\x00FML\x00", "mc.example.com"},
+		{"mc.example.com\x00192.168.1.5\x00uuid", "mc.example.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeHostname(tt.addr); got != tt.want {
+			t.Errorf("normalizeHostname(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestPeekLoginStart(t *testing.T) {
+	uuid := []byte{0x06, 0x9a, 0x79, 0xf4, 0x44, 0xe9, 0x47, 0x26, 0xa5, 0xbe, 0xfc, 0xa9, 0x0e, 0x38, 0xaa, 0xf5}
+	const dashed = "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+	name := appendString(nil, "Steve")
+	// A 1.19 chat signing key: has, expiry, public key, signature
+	signingKey := append([]byte{0x01}, make([]byte, 8)...)
+	signingKey = append(append(signingKey, appendVarInt(nil, 4)...), 1, 2, 3, 4)
+	signingKey = append(append(signingKey, appendVarInt(nil, 2)...), 5, 6)
+	oversized := append(appendVarInt(nil, maxLoginStartSize+1), make([]byte, 16)...)
+	full := packet(0x00, append(append([]byte{}, name...), uuid...))
+
+	tests := []struct {
+		name     string
+		protocol int32
+		data     []byte
+		want     loginStart
+		wantErr  bool
+	}{
+		{"1.21", 767, full, loginStart{Name: "Steve", UUID: dashed}, false},
+		{"1.20.2 without the UUID", 764, packet(0x00, name), loginStart{Name: "Steve"}, false},
+		{"1.19.3 with a UUID", 761, packet(0x00, append(append(append([]byte{}, name...), 0x01), uuid...)), loginStart{Name: "Steve", UUID: dashed}, false},
+		{"1.19.3 without a UUID", 761, packet(0x00, append(append([]byte{}, name...), 0x00)), loginStart{Name: "Steve"}, false},
+		{"1.19.1 with a signing key", 760, packet(0x00, append(append(append(append([]byte{}, name...), signingKey...), 0x01), uuid...)), loginStart{Name: "Steve", UUID: dashed}, false},
+		{"1.19.1 without a signing key", 760, packet(0x00, append(append(append([]byte{}, name...), 0x00, 0x01), uuid...)), loginStart{Name: "Steve", UUID: dashed}, false},
+		{"1.19.1 with a truncated signing key", 760, packet(0x00, append(append([]byte{}, name...), signingKey[:12]...)), loginStart{Name: "Steve"}, false},
+		{"1.18.2 sends only the name", 758, full, loginStart{Name: "Steve"}, false},
+		{"truncated UUID", 767, packet(0x00, append(append([]byte{}, name...), uuid[:8]...)), loginStart{Name: "Steve"}, false},
+		{"truncated name", 767, packet(0x00, []byte{0x05, 'S', 't'}), loginStart{}, false},
+		{"other packet", 767, packet(0x01, name), loginStart{}, false},
+		{"empty", 767, nil, loginStart{}, true},
+		{"zero length", 767, []byte{0x00}, loginStart{}, true},
+		{"oversized", 767, oversized, loginStart{}, true},
+		{"truncated length", 767, []byte{0x80}, loginStart{}, true},
+		{"truncated body", 767, full[:len(full)-4], loginStart{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, raw, err := peekLoginStart(connOf(tt.data), tt.protocol)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if login != tt.want {
+				t.Errorf("login = %+v, want %+v", login, tt.want)
+			}
+			if !bytes.HasPrefix(tt.data, raw) {
+				t.Errorf("raw bytes %x are not what was read from %x", raw, tt.data)
+			}
+			if !tt.wantErr && len(raw) != len(tt.data) {
+				t.Errorf("consumed %d bytes, want the whole packet of %d", len(raw), len(tt.data))
+			}
+		})
+	}
+}
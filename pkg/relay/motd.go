@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OfflineStatus is what the relay tells Java players while no host is
+// connected: a server list entry and a disconnect message for logins
+type OfflineStatus struct {
+	MOTD          string `json:"motd"`
+	Version       string `json:"version,omitempty"` // Shown in place of the player count when set
+	PlayersOnline int    `json:"players_online"`
+	PlayersMax    int    `json:"players_max"`
+	Favicon       string `json:"favicon,omitempty"` // 64x64 PNG path (relative to the file) or data URI
+	KickMessage   string `json:"kick_message"`
+}
+
+// DefaultOfflineStatus is used when no MOTD file is configured
+func DefaultOfflineStatus() OfflineStatus {
+	return OfflineStatus{
+		MOTD:        "Server is offline",
+		Version:     "Offline",
+		KickMessage: "Server is starting, try again shortly",
+	}
+}
+
+// LoadOfflineStatus reads an OfflineStatus JSON file. Missing fields keep
+// their defaults and a favicon path is inlined as a data URI.
+func LoadOfflineStatus(path string) (OfflineStatus, error) {
+	status := DefaultOfflineStatus()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("invalid MOTD file %s: %w", path, err)
+	}
+
+	if status.Favicon != "" && !strings.HasPrefix(status.Favicon, "data:") {
+		iconPath := status.Favicon
+		if !filepath.IsAbs(iconPath) {
+			iconPath = filepath.Join(filepath.Dir(path), iconPath)
+		}
+		icon, err := os.ReadFile(iconPath)
+		if err != nil {
+			return status, fmt.Errorf("failed to read favicon: %w", err)
+		}
+		status.Favicon = "data:image/png;base64," + base64.StdEncoding.EncodeToString(icon)
+	}
+	return status, nil
+}
+
+// response renders the status as a server list JSON response for a client
+// speaking protocolVersion
+func (s OfflineStatus) response(protocolVersion int32) string {
+	// A protocol the client does not speak makes it show the version
+	// name instead of the player count
+	protocol := protocolVersion
+	if s.Version != "" {
+		protocol = -1
+	}
+
+	response := map[string]any{
+		"version":     map[string]any{"name": s.Version, "protocol": protocol},
+		"players":     map[string]any{"max": s.PlayersMax, "online": s.PlayersOnline},
+		"description": map[string]string{"text": s.MOTD},
+	}
+	if s.Favicon != "" {
+		response["favicon"] = s.Favicon
+	}
+	data, _ := json.Marshal(response)
+	return string(data)
+}
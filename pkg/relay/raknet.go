@@ -0,0 +1,204 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tunnel/pkg/tunnel"
+)
+
+// RakNet offline messages used by Bedrock server list pings. Answering them
+// at the relay keeps scanners and server lists from opening streams.
+
+var raknetMagic = []byte{0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78}
+
+const (
+	raknetUnconnectedPing     = 0x01
+	raknetUnconnectedPingOpen = 0x02
+	raknetUnconnectedPong     = 0x1c
+
+	// bedrockPongRefresh is how often the relay asks the host for a fresh pong
+	bedrockPongRefresh = 5 * time.Second
+
+	// bedrockPingTimeout bounds one pong fetch through the tunnel
+	bedrockPingTimeout = 3 * time.Second
+)
+
+// isUnconnectedPing reports whether a datagram is a RakNet unconnected ping:
+// ID, 8-byte time, magic, 8-byte client GUID
+func isUnconnectedPing(data []byte) bool {
+	return len(data) >= 33 &&
+		(data[0] == raknetUnconnectedPing || data[0] == raknetUnconnectedPingOpen) &&
+		bytes.Equal(data[9:25], raknetMagic)
+}
+
+// unconnectedPong builds a pong answering a ping sent at pingTime
+func unconnectedPong(pingTime []byte, guid int64, advert string) []byte {
+	buf := make([]byte, 0, 35+len(advert))
+	buf = append(buf, raknetUnconnectedPong)
+	buf = append(buf, pingTime...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(guid))
+	buf = append(buf, raknetMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(advert)))
+	return append(buf, advert...)
+}
+
+// parseUnconnectedPong extracts the server GUID and advertisement string
+func parseUnconnectedPong(data []byte) (int64, string, error) {
+	if len(data) < 35 || data[0] != raknetUnconnectedPong || !bytes.Equal(data[17:33], raknetMagic) {
+		return 0, "", errors.New("not an unconnected pong")
+	}
+	guid := int64(binary.BigEndian.Uint64(data[9:17]))
+	n := int(binary.BigEndian.Uint16(data[33:35]))
+	if 35+n > len(data) {
+		return 0, "", errors.New("truncated pong")
+	}
+	return guid, string(data[35 : 35+n]), nil
+}
+
+// bedrockPong is the last advertisement seen from the host's Bedrock server
+type bedrockPong struct {
+	mu          sync.Mutex
+	advert      string
+	guid        int64
+	offlineGUID int64 // Server GUID the relay uses for its own offline pong
+}
+
+func (p *bedrockPong) get() (string, int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.advert, p.guid, p.advert != ""
+}
+
+func (p *bedrockPong) set(advert string, guid int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.advert, p.guid = advert, guid
+}
+
+// answerBedrockPing replies to an unconnected ping from the cached pong, or
+// with the offline MOTD while no host is connected. It returns false when
+// there is nothing to answer with and the ping should reach the host.
+func (r *Relay) answerBedrockPing(conn *net.UDPConn, addr *net.UDPAddr, data []byte) bool {
+	pingTime := data[1:9]
+
+	if r.hosts.primary() == nil {
+		conn.WriteToUDP(unconnectedPong(pingTime, r.bedrockPong.offlineGUID, r.offlineBedrockAdvert()), addr)
+		return true
+	}
+
+	advert, guid, ok := r.bedrockPong.get()
+	if !ok {
+		return false
+	}
+	conn.WriteToUDP(unconnectedPong(pingTime, guid, advert), addr)
+	return true
+}
+
+// refreshBedrockPong keeps the cached pong current while a host that can
+// answer pings is connected
+func (r *Relay) refreshBedrockPong() {
+	ticker := time.NewTicker(bedrockPongRefresh)
+	defer ticker.Stop()
+
+	failing := false
+	for ; ; <-ticker.C {
+		err := r.fetchBedrockPong()
+		if errors.Is(err, errNoHost) {
+			continue
+		}
+		if err != nil && !failing {
+			r.logf(LevelWarn, "Bedrock", nil, "Failed to refresh server list pong: %v", err)
+		}
+		failing = err != nil
+	}
+}
+
+// fetchBedrockPong pings the host's Bedrock server through a "ping:" stream
+func (r *Relay) fetchBedrockPong() error {
+	host := r.hosts.withFeature(tunnel.FeatureBedrockPing)
+	if host == nil {
+		return errNoHost
+	}
+
+	stream, err := host.session.Open()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(bedrockPingTimeout))
+
+	ping := []byte{raknetUnconnectedPing}
+	ping = binary.BigEndian.AppendUint64(ping, uint64(time.Now().UnixMilli()))
+	ping = append(ping, raknetMagic...)
+	ping = binary.BigEndian.AppendUint64(ping, uint64(r.bedrockPong.offlineGUID))
+
+	msg := []byte("ping:\n")
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(ping)))
+	if _, err := stream.Write(append(msg, ping...)); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(stream)
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(br, lenBuf); err != nil {
+		return err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(br, reply); err != nil {
+		return err
+	}
+
+	guid, advert, err := parseUnconnectedPong(reply)
+	if err != nil {
+		return err
+	}
+	r.bedrockPong.set(r.rewriteBedrockPorts(advert), guid)
+	return nil
+}
+
+// rewriteBedrockPorts replaces the local server's ports in an advertisement
+// with the relay's public Bedrock port
+func (r *Relay) rewriteBedrockPorts(advert string) string {
+	fields := strings.Split(advert, ";")
+	port := strconv.Itoa(r.Config.BedrockPort)
+	for _, i := range []int{10, 11} {
+		if i < len(fields) && fields[i] != "" {
+			fields[i] = port
+		}
+	}
+	return strings.Join(fields, ";")
+}
+
+// offlineBedrockAdvert renders the offline status as a Bedrock
+// advertisement, borrowing the protocol and version from the last pong
+func (r *Relay) offlineBedrockAdvert() string {
+	status := r.Config.OfflineStatus
+	protocol, version := "0", status.Version
+	if advert, _, ok := r.bedrockPong.get(); ok {
+		fields := strings.Split(advert, ";")
+		if len(fields) > 3 {
+			protocol, version = fields[2], fields[3]
+		}
+	}
+
+	// Semicolons separate fields, so they cannot appear in the text
+	clean := strings.NewReplacer(";", ":", "\r", "").Replace
+	line1, line2, _ := strings.Cut(clean(status.MOTD), "\n")
+	port := strconv.Itoa(r.Config.BedrockPort)
+
+	return strings.Join([]string{
+		"MCPE", line1, protocol, clean(version),
+		strconv.Itoa(status.PlayersOnline), strconv.Itoa(status.PlayersMax),
+		strconv.FormatUint(uint64(r.bedrockPong.offlineGUID), 10),
+		line2, "Survival", "1", port, port, "",
+	}, ";")
+}
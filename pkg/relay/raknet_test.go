@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// unconnectedPing builds a RakNet unconnected ping with the given ID
+func unconnectedPing(id byte, pingTime uint64, guid uint64) []byte {
+	ping := binary.BigEndian.AppendUint64([]byte{id}, pingTime)
+	ping = append(ping, raknetMagic...)
+	return binary.BigEndian.AppendUint64(ping, guid)
+}
+
+func TestIsUnconnectedPing(t *testing.T) {
+	ping := unconnectedPing(raknetUnconnectedPing, 1234, 42)
+	badMagic := append([]byte{}, ping...)
+	badMagic[12] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"ping", ping, true},
+		{"open connections ping", unconnectedPing(raknetUnconnectedPingOpen, 1234, 42), true},
+		{"with trailing data", append(append([]byte{}, ping...), 0x00, 0x01), true},
+		{"truncated", ping[:32], false},
+		{"only the ID", ping[:1], false},
+		{"empty", nil, false},
+		{"wrong magic", badMagic, false},
+		{"pong", unconnectedPong(ping[1:9], 42, "MCPE;x"), false},
+		{"connected datagram", append([]byte{0x84}, ping[1:]...), false},
+	}
+	for _, tt := range tests {
+		if got := isUnconnectedPing(tt.data); got != tt.want {
+			t.Errorf("%s: isUnconnectedPing = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnconnectedPongRoundTrip(t *testing.T) {
+	pingTime := binary.BigEndian.AppendUint64(nil, 987654321)
+	advert := "MCPE;Dedicated Server;766;1.21.50;0;10;13253860892328930865;Bedrock level;Survival;1;19132;19133;"
+
+	pong := unconnectedPong(pingTime, -7, advert)
+	if pong[0] != raknetUnconnectedPong {
+		t.Fatalf("packet ID 0x%02x, want 0x%02x", pong[0], raknetUnconnectedPong)
+	}
+	if !bytes.Equal(pong[1:9], pingTime) {
+		t.Errorf("ping time %x not echoed, got %x", pingTime, pong[1:9])
+	}
+
+	guid, got, err := parseUnconnectedPong(pong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guid != -7 || got != advert {
+		t.Errorf("parsed %d %q, want %d %q", guid, got, -7, advert)
+	}
+}
+
+func TestParseUnconnectedPong(t *testing.T) {
+	pong := unconnectedPong(make([]byte, 8), 42, "MCPE;Server")
+	oversized := append([]byte{}, pong...)
+	binary.BigEndian.PutUint16(oversized[33:35], 0xffff)
+	badMagic := append([]byte{}, pong...)
+	badMagic[20] ^= 0xff
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantAdvert string
+		wantErr    bool
+	}{
+		{"pong", pong, "MCPE;Server", false},
+		{"empty advertisement", unconnectedPong(make([]byte, 8), 42, ""), "", false},
+		{"trailing data", append(append([]byte{}, pong...), 0x00), "MCPE;Server", false},
+		{"truncated advertisement", pong[:len(pong)-1], "", true},
+		{"length past the end", oversized, "", true},
+		{"missing length", pong[:34], "", true},
+		{"wrong magic", badMagic, "", true},
+		{"ping", unconnectedPing(raknetUnconnectedPing, 1, 2), "", true},
+		{"empty", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, advert, err := parseUnconnectedPong(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if advert != tt.wantAdvert {
+				t.Errorf("advertisement = %q, want %q", advert, tt.wantAdvert)
+			}
+		})
+	}
+}
+
+func TestRewriteBedrockPorts(t *testing.T) {
+	r := New(Config{BedrockPort: 19200})
+	tests := []struct {
+		name   string
+		advert string
+		want   string
+	}{
+		{
+			name:   "both ports",
+			advert: "MCPE;Server;766;1.21.50;0;10;123;Level;Survival;1;19132;19133;",
+			want:   "MCPE;Server;766;1.21.50;0;10;123;Level;Survival;1;19200;19200;",
+		},
+		{
+			name:   "no IPv6 port",
+			advert: "MCPE;Server;766;1.21.50;0;10;123;Level;Survival;1;19132;;",
+			want:   "MCPE;Server;766;1.21.50;0;10;123;Level;Survival;1;19200;;",
+		},
+		{
+			name:   "no ports",
+			advert: "MCPE;Server;766;1.21.50;0;10;123;Level;Survival;1",
+			want:   "MCPE;Server;766;1.21.50;0;10;123;Level;Survival;1",
+		},
+		{name: "empty", advert: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := r.rewriteBedrockPorts(tt.advert); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,44 +1,172 @@
 package relay
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"tunnel/pkg/tunnel"
+
 	"github.com/hashicorp/yamux"
 )
 
 type Config struct {
 	ControlPort int
-	GamePort    int // Java Edition TCP port (default 25565)
-	BedrockPort int // Bedrock Edition UDP port (default 19132, 0 to disable)
+	GamePort    int    // Java Edition TCP port (default 25565)
+	BedrockPort int    // Bedrock Edition UDP port (default 19132, 0 to disable)
+	Token       string // Shared secret the host must prove it knows
+
+	// Control channel TLS (disabled when TLSCertFile is empty)
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Require hosts to present a client certificate issued by the CA in
+	// this directory (see CertAuthority). Requires TLS.
+	ClientCADir string
+
+	// What to do when a host connects while another is active
+	// (HostPolicyReplace, HostPolicyReject, HostPolicyStandby or
+	// HostPolicyBalance)
+	HostPolicy string
+
+	// How HostPolicyBalance picks a host for each new player
+	// (BalanceRoundRobin, BalanceLeastConnections or BalanceWeighted)
+	BalanceStrategy string
+
+	// Shown to Java players whose server address no host serves
+	UnknownHostMessage string
+
+	// Server list entry and login message while no host is connected
+	// (DefaultOfflineStatus when zero)
+	OfflineStatus OfflineStatus
+
+	// How long the relay reuses a host's server list response before
+	// asking again (0 forwards every status ping to the host)
+	StatusCacheTTL time.Duration
+
+	// Bedrock sessions silent for this long are closed (default 30s) and
+	// at most BedrockMaxSessions run at once (0 for no limit)
+	BedrockIdleTimeout time.Duration
+	BedrockMaxSessions int
+
+	// Connection rate and concurrency limits for Java and Bedrock players
+	Limits Limits
+
+	// Allow/deny lists and bans for player addresses (empty and kept in
+	// memory when nil)
+	ACL *ACL
+
+	// Listen address, tokens and TLS for the HTTP API
+	API APIConfig
+
+	// Events below LogLevel are dropped. The rest go to API subscribers
+	// and, when LogOutput is set, are written to it as LogFormat lines.
+	LogLevel  LogLevel
+	LogFormat string // LogFormatText (default) or LogFormatJSON
+	LogOutput io.Writer
+
+	// Recent log events kept for replay to new subscribers and
+	// /logs/history (DefaultLogHistory when 0)
+	LogHistory int
+
+	// URLs that receive events as they happen
+	Webhooks []Webhook
+
+	// Publish EventTunnelDown after this long without a host (0 to
+	// disable)
+	TunnelDownAfter time.Duration
 }
 
+// DefaultUnknownHostMessage is shown when Config.UnknownHostMessage is empty
+const DefaultUnknownHostMessage = "No server is available at this address"
+
+// DefaultBedrockIdleTimeout is used when Config.BedrockIdleTimeout is zero
+const DefaultBedrockIdleTimeout = 30 * time.Second
+
+// nameDeniedMessage disconnects Java players refused by the name lists
+const nameDeniedMessage = "You are not allowed on this server"
+
+// playerHandshakeTimeout bounds how long a player may take to send the
+// Minecraft handshake
+const playerHandshakeTimeout = 5 * time.Second
+
 type Relay struct {
 	Config Config
 
 	// State
-	tunnelSession *yamux.Session
-	tunnelMutex   sync.Mutex
-	GlobalBytes   int64
-	ActivePlayers int64
-	PublicIP      string
-	StartTime     time.Time
+	hosts          hostPool
+	javaTraffic    Traffic
+	bedrockTraffic Traffic
+	connections    connRegistry // Players currently carried to a host
+	PublicIP       string
+	StartTime      time.Time
+
+	// Bedrock sessions by player address, and how past sessions ended
+	bedrockSessions map[string]*bedrockSession
+	bedrockMu       sync.Mutex
+	BedrockReaped   int64 // Closed by the idle reaper
+	BedrockClosed   int64 // Closed by the player or host
+
+	// Player connection limits and access lists
+	limits      *limiter
+	acl         *ACL
+	aclRejected int64
+	rejectLog   logThrottle
+
+	// Counters exported only through /metrics
+	javaAccepted    int64 // Player connections past the ACL and limits
+	bedrockAccepted int64
+	streamFailures  int64 // Streams a host session refused to open
+	hostConnects    int64 // Host sessions established
+
+	// Server list responses answered without the host
+	statusCache statusCache
+	bedrockPong bedrockPong
+
+	// Host certificate authority (nil unless ClientCADir is set)
+	ca *CertAuthority
 
 	// Logging
 	logBroadcaster *LogBroadcaster
+	logMu          sync.Mutex // Serializes writes to Config.LogOutput
+
+	// Typed events for /events subscribers
+	events eventBus
 }
 
 func New(cfg Config) *Relay {
+	if cfg.HostPolicy == "" {
+		cfg.HostPolicy = HostPolicyStandby
+	}
+	if cfg.UnknownHostMessage == "" {
+		cfg.UnknownHostMessage = DefaultUnknownHostMessage
+	}
+	if cfg.OfflineStatus == (OfflineStatus{}) {
+		cfg.OfflineStatus = DefaultOfflineStatus()
+	}
+	if cfg.BedrockIdleTimeout <= 0 {
+		cfg.BedrockIdleTimeout = DefaultBedrockIdleTimeout
+	}
+	if cfg.ACL == nil {
+		cfg.ACL = &ACL{}
+	}
 	return &Relay{
-		Config:         cfg,
-		logBroadcaster: NewLogBroadcaster(),
-		PublicIP:       "Fetching...",
-		StartTime:      time.Now(),
+		Config:          cfg,
+		bedrockSessions: make(map[string]*bedrockSession),
+		limits:          newLimiter(cfg.Limits),
+		acl:             cfg.ACL,
+		logBroadcaster:  NewLogBroadcaster(cfg.LogHistory),
+		PublicIP:        "Fetching...",
+		StartTime:       time.Now(),
 	}
 }
 
@@ -51,14 +179,14 @@ func (r *Relay) Start() {
 			defer resp.Body.Close()
 			body, _ := io.ReadAll(resp.Body)
 			r.PublicIP = string(body)
-			r.Log(fmt.Sprintf("Public IP: %s", r.PublicIP))
+			r.logf(LevelInfo, "", nil, "Public IP: %s", r.PublicIP)
 		} else {
 			r.PublicIP = "Unknown"
-			r.Log("Failed to fetch Public IP")
+			r.logf(LevelWarn, "", nil, "Failed to fetch Public IP: %v", err)
 		}
 	}()
 
-	r.Log("Starting listeners...")
+	r.logf(LevelInfo, "", nil, "Starting listeners...")
 	go r.startControlServer()
 	go r.startGameServer()
 
@@ -66,64 +194,201 @@ func (r *Relay) Start() {
 	if r.Config.BedrockPort > 0 {
 		go r.startBedrockServer()
 	}
-}
 
-func (r *Relay) Log(msg string) {
-	// Broadcast log to all listeners
-	r.logBroadcaster.Broadcast(msg)
+	r.startWebhooks()
+	if r.Config.TunnelDownAfter > 0 {
+		go r.watchTunnel()
+	}
 }
 
 func (r *Relay) startControlServer() {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", r.Config.ControlPort))
 	if err != nil {
-		r.Log(fmt.Sprintf("[Control] Listener failed: %v", err))
+		r.logf(LevelError, "Control", nil, "Listener failed: %v", err)
 		return
 	}
-	r.Log(fmt.Sprintf("[Control] Listening on :%d", r.Config.ControlPort))
+
+	if r.Config.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.Config.TLSCertFile, r.Config.TLSKeyFile)
+		if err != nil {
+			r.logf(LevelError, "Control", nil, "Failed to load TLS certificate: %v", err)
+			listener.Close()
+			return
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		if r.Config.ClientCADir != "" {
+			r.ca, err = OpenCA(r.Config.ClientCADir)
+			if err != nil {
+				r.logf(LevelError, "Control", nil, "Failed to open host CA: %v", err)
+				listener.Close()
+				return
+			}
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			tlsConfig.ClientCAs = r.ca.Pool()
+			tlsConfig.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+				if len(chains) > 0 && r.ca.IsRevoked(chains[0][0]) {
+					return errors.New("client certificate has been revoked")
+				}
+				return nil
+			}
+		}
+
+		listener = tls.NewListener(listener, tlsConfig)
+		if r.ca != nil {
+			r.logf(LevelInfo, "Control", nil, "Listening on :%d (mutual TLS)", r.Config.ControlPort)
+		} else {
+			r.logf(LevelInfo, "Control", nil, "Listening on :%d (TLS)", r.Config.ControlPort)
+		}
+	} else {
+		if r.Config.ClientCADir != "" {
+			r.logf(LevelError, "Control", nil, "Client certificates require TLS, refusing to start")
+			listener.Close()
+			return
+		}
+		r.logf(LevelInfo, "Control", nil, "Listening on :%d", r.Config.ControlPort)
+	}
+	if r.Config.Token == "" && r.ca == nil {
+		r.logf(LevelWarn, "Control", nil, "No token configured, control port is unauthenticated")
+	}
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			r.Log(fmt.Sprintf("[Control] Accept error: %v", err))
+			r.logf(LevelWarn, "Control", nil, "Accept error: %v", err)
 			continue
 		}
 
-		r.Log(fmt.Sprintf("[Control] Connection from %s", conn.RemoteAddr()))
+		go r.handleControl(conn)
+	}
+}
 
-		config := yamux.DefaultConfig()
-		config.KeepAliveInterval = 10 * time.Second
+// handleControl authenticates a host connection and installs its yamux session
+func (r *Relay) handleControl(conn net.Conn) {
+	r.logf(LevelDebug, "Control", nil, "Connection from %s", conn.RemoteAddr())
 
-		session, err := yamux.Server(conn, config)
-		if err != nil {
-			r.Log(fmt.Sprintf("[Control] Yamux session failed: %v", err))
+	var hostCert *x509.Certificate
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tunnel.HandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			r.logf(LevelWarn, "Control", nil, "TLS handshake failed for %s: %v", conn.RemoteAddr(), err)
 			conn.Close()
-			continue
+			return
 		}
+		tlsConn.SetDeadline(time.Time{})
 
-		r.tunnelMutex.Lock()
-		if r.tunnelSession != nil {
-			r.Log("[Control] Overwriting existing session")
-			r.tunnelSession.Close()
+		if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+			hostCert = peers[0]
+			r.logf(LevelInfo, "Control", nil, "Host certificate: %s (serial %s)", hostCert.Subject.CommonName, hostCert.SerialNumber.Text(16))
 		}
-		r.tunnelSession = session
-		r.tunnelMutex.Unlock()
+	}
+
+	if err := tunnel.ServerAuth(conn, r.Config.Token); err != nil {
+		r.logf(LevelWarn, "Control", nil, "Authentication failed for %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	hello, reply, err := tunnel.ServerHello(conn, r.acceptHello)
+	if errors.Is(err, tunnel.ErrRejected) {
+		r.logf(LevelWarn, "Control", nil, "Rejected host %s: %s", conn.RemoteAddr(), reply.Error)
+		conn.Close()
+		return
+	}
+	if err != nil {
+		r.logf(LevelWarn, "Control", nil, "Handshake failed for %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	r.logf(LevelInfo, "Control", nil, "Host %s speaks protocol v%d (client %s, features: %s)",
+		conn.RemoteAddr(), hello.ProtocolVersion, hello.ClientVersion, strings.Join(reply.Features, ","))
+
+	config := yamux.DefaultConfig()
+	config.KeepAliveInterval = 10 * time.Second
+
+	session, err := yamux.Server(conn, config)
+	if err != nil {
+		r.logf(LevelWarn, "Control", nil, "Yamux session failed: %v", err)
+		conn.Close()
+		return
+	}
+
+	host := &hostSession{
+		session:       session,
+		remoteAddr:    conn.RemoteAddr().String(),
+		clientVersion: hello.ClientVersion,
+		features:      reply.Features,
+		hostnames:     normalizeHostnames(hello.Hostnames),
+		priority:      hello.Priority,
+		weight:        hello.Weight,
+		connectedAt:   time.Now(),
+	}
+	if host.weight <= 0 {
+		host.weight = 1
+	}
+	if hostCert != nil {
+		host.identity = hostCert.Subject.CommonName
+	}
+	if len(host.hostnames) > 0 {
+		r.logf(LevelInfo, "Control", nil, "Host %s serves %s", conn.RemoteAddr(), strings.Join(host.hostnames, ", "))
+	}
+
+	r.addHost(host, hostCert)
+}
+
+// acceptHello checks a host's hello and builds the relay's reply
+func (r *Relay) acceptHello(hello tunnel.Hello) tunnel.HelloReply {
+	reply := tunnel.HelloReply{
+		GamePort:    r.Config.GamePort,
+		BedrockPort: r.Config.BedrockPort,
+	}
+	if r.Config.BedrockPort > 0 {
+		reply.UDPIdleTimeout = int(r.Config.BedrockIdleTimeout / time.Second)
+	}
+
+	if hello.ProtocolVersion != tunnel.ProtocolVersion {
+		reply.Error = fmt.Sprintf("protocol version %d not supported (relay %s speaks v%d)",
+			hello.ProtocolVersion, tunnel.Version, tunnel.ProtocolVersion)
+		return reply
+	}
+	if !tunnel.HasFeature(hello.Features, tunnel.FeatureStreamHeader) {
+		reply.Error = fmt.Sprintf("required feature %q missing", tunnel.FeatureStreamHeader)
+		return reply
+	}
+
+	group := strings.Join(normalizeHostnames(hello.Hostnames), ",")
+	if r.Config.HostPolicy == HostPolicyReject && r.hosts.hasHealthyPrimary(group) {
+		reply.Error = errHostRejected.Error()
+		reply.Retry = true
+		return reply
+	}
 
-		r.Log("[Control] Tunnel established")
+	if hello.GamePort != 0 && hello.GamePort != r.Config.GamePort {
+		r.logf(LevelWarn, "Control", nil, "Host expects game port %d, relay serves %d", hello.GamePort, r.Config.GamePort)
 	}
+	if hello.BedrockPort != 0 && hello.BedrockPort != r.Config.BedrockPort {
+		r.logf(LevelWarn, "Control", nil, "Host expects Bedrock port %d, relay serves %d", hello.BedrockPort, r.Config.BedrockPort)
+	}
+
+	reply.Features = tunnel.Negotiate(hello.Features)
+	return reply
 }
 
 func (r *Relay) startGameServer() {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", r.Config.GamePort))
 	if err != nil {
-		r.Log(fmt.Sprintf("[Game] Listener failed: %v", err))
+		r.logf(LevelError, "Game", nil, "Listener failed: %v", err)
 		return
 	}
-	r.Log(fmt.Sprintf("[Game] Listening on :%d", r.Config.GamePort))
+	r.logf(LevelInfo, "Game", nil, "Listening on :%d", r.Config.GamePort)
 
 	for {
 		playerConn, err := listener.Accept()
 		if err != nil {
-			r.Log(fmt.Sprintf("[Game] Accept error: %v", err))
+			r.logf(LevelWarn, "Game", nil, "Accept error: %v", err)
 			continue
 		}
 
@@ -134,78 +399,196 @@ func (r *Relay) startGameServer() {
 func (r *Relay) handlePlayer(playerConn net.Conn) {
 	defer playerConn.Close()
 
-	r.tunnelMutex.Lock()
-	session := r.tunnelSession
-	r.tunnelMutex.Unlock()
+	if !r.checkACL("Game", playerConn.RemoteAddr()) {
+		return
+	}
+	release := r.admit("Game", playerConn.RemoteAddr())
+	if release == nil {
+		return
+	}
+	defer release()
+	atomic.AddInt64(&r.javaAccepted, 1)
+
+	// Peek the handshake to learn which server the player asked for
+	playerConn.SetReadDeadline(time.Now().Add(playerHandshakeTimeout))
+	hs, peeked, err := peekHandshake(playerConn)
+	playerConn.SetReadDeadline(time.Time{})
+	if err != nil {
+		r.logf(LevelDebug, "Game", nil, "Failed to read handshake from %s: %v", playerConn.RemoteAddr(), err)
+		return
+	}
+
+	// Server list pings never need a stream of their own when cached
+	if hs.NextState == stateStatus && hs.ProtocolVersion != 0 && r.Config.StatusCacheTTL > 0 {
+		r.serveCachedStatus(playerConn, hs, peeked)
+		return
+	}
 
-	if session == nil {
+	// Answer for the server while the tunnel is down
+	if r.hosts.primary() == nil {
+		if hs.NextState != stateStatus {
+			r.logf(LevelWarn, "Game", nil, "Tunnel down, disconnecting %s", playerConn.RemoteAddr())
+		}
+		r.rejectUnroutable(playerConn, hs)
 		return
 	}
 
-	r.Log(fmt.Sprintf("[Game] Player connected: %s", playerConn.RemoteAddr()))
-	atomic.AddInt64(&r.ActivePlayers, 1)
-	defer atomic.AddInt64(&r.ActivePlayers, -1)
+	// Logins name the player in the next packet
+	var login loginStart
+	if hs.NextState == stateLogin || hs.NextState == stateTransfer {
+		playerConn.SetReadDeadline(time.Now().Add(playerHandshakeTimeout))
+		var raw []byte
+		login, raw, err = peekLoginStart(playerConn, hs.ProtocolVersion)
+		playerConn.SetReadDeadline(time.Time{})
+		peeked = append(peeked, raw...)
+		if err != nil {
+			r.logf(LevelDebug, "Game", nil, "Failed to read login from %s: %v", playerConn.RemoteAddr(), err)
+			return
+		}
+		if ok, reason := r.acl.CheckName(login.Name, login.UUID); !ok {
+			atomic.AddInt64(&r.aclRejected, 1)
+			r.logf(LevelWarn, "Game", nil, "Rejected %s: %s", playerLabel(playerConn.RemoteAddr(), login), reason)
+			r.emit(Event{Type: EventACLDenied, Edition: "java", RemoteAddr: playerConn.RemoteAddr().String(), Player: login.Name, UUID: login.UUID, Reason: reason})
+			playerConn.SetDeadline(time.Now().Add(playerHandshakeTimeout))
+			writeLoginDisconnect(playerConn, nameDeniedMessage)
+			return
+		}
+	}
+
+	player := playerLabel(playerConn.RemoteAddr(), login)
+	fields := Fields{"remote_addr": playerConn.RemoteAddr().String()}
+	if login.Name != "" {
+		fields["player"] = login.Name
+	}
+	if hs.ServerAddress != "" {
+		fields["hostname"] = hs.ServerAddress
+		r.logf(LevelInfo, "Game", fields, "Player connected: %s (%s)", player, hs.ServerAddress)
+	} else {
+		r.logf(LevelInfo, "Game", fields, "Player connected: %s", player)
+	}
 
-	stream, err := session.Open()
+	host, stream, err := r.openStream(hs.ServerAddress)
+	if errors.Is(err, errNoHost) {
+		r.logf(LevelWarn, "Game", nil, "No host serves %q, disconnecting %s", hs.ServerAddress, playerConn.RemoteAddr())
+		r.rejectUnroutable(playerConn, hs)
+		return
+	}
 	if err != nil {
-		r.Log(fmt.Sprintf("[Game] Failed to open stream: %v", err))
+		r.logf(LevelWarn, "Game", nil, "Failed to open stream: %v", err)
 		return
 	}
+	defer host.release()
 	defer stream.Close()
 
-	// Send Player IP Header with protocol type
-	// Format: "tcp:<IP:PORT>\n" for Java, "udp:<IP:PORT>\n" for Bedrock
-	if _, err := stream.Write([]byte("tcp:" + playerConn.RemoteAddr().String() + "\n")); err != nil {
-		r.Log(fmt.Sprintf("[Game] Failed to send header: %v", err))
+	conn := &connection{
+		protocol:   "tcp",
+		remoteAddr: playerConn.RemoteAddr(),
+		hostname:   hs.ServerAddress,
+		login:      login,
+		host:       host,
+		total:      &r.javaTraffic,
+	}
+	out := &loginWriter{w: playerConn}
+	conn.kick = func(reason string) {
+		stream.Close()
+		// Unblock a write to a stalled player so the disconnect can go out
+		playerConn.SetWriteDeadline(time.Now().Add(time.Second))
+		if hs.NextState == stateLogin || hs.NextState == stateTransfer {
+			out.disconnect(reason)
+		}
+		playerConn.Close()
+	}
+	r.connections.add(conn)
+	defer r.connections.remove(conn)
+
+	// Server list pings are not players joining
+	if hs.NextState != stateStatus {
+		r.emit(conn.event(EventPlayerConnected))
+		defer func() { r.emit(conn.event(EventPlayerDisconnected)) }()
+	}
+
+	// Send Player IP Header with protocol type, and the player's name to
+	// hosts that understand it
+	header := tunnel.StreamHeader{Protocol: "tcp", Addr: playerConn.RemoteAddr().String(), Name: login.Name, UUID: login.UUID}
+	if _, err := stream.Write([]byte(header.Format(tunnel.HasFeature(host.features, tunnel.FeaturePlayerName)))); err != nil {
+		r.logf(LevelWarn, "Game", nil, "Failed to send header: %v", err)
+		return
+	}
+
+	// Replay the handshake we consumed while routing
+	if _, err := stream.Write(peeked); err != nil {
+		r.logf(LevelWarn, "Game", nil, "Failed to forward handshake: %v", err)
 		return
 	}
+	conn.count(true, len(peeked), 0)
 
 	// Bidirectional copy with traffic counting
 	done := make(chan struct{})
 
 	go func() {
 		// Stream -> Player
-		io.Copy(playerConn, &CountingReader{r: stream, counter: &r.GlobalBytes})
+		io.Copy(out, conn.reader(stream, false))
 		done <- struct{}{}
 	}()
 
 	go func() {
 		// Player -> Stream
-		io.Copy(stream, &CountingReader{r: playerConn, counter: &r.GlobalBytes})
+		io.Copy(stream, conn.reader(playerConn, true))
 		done <- struct{}{}
 	}()
 
 	<-done
-	r.Log(fmt.Sprintf("[Game] Player disconnected: %s", playerConn.RemoteAddr()))
+	r.logf(LevelInfo, "Game", conn.disconnectFields(), "Player disconnected: %s", player)
+}
+
+// playerLabel names a Java player in log lines
+func playerLabel(addr net.Addr, login loginStart) string {
+	if login.Name == "" {
+		return addr.String()
+	}
+	return fmt.Sprintf("%s as %s", addr, login.Name)
+}
+
+// rejectUnroutable answers a Java player no host can take: with the
+// offline status while the tunnel is down, else the unknown host message
+func (r *Relay) rejectUnroutable(conn net.Conn, hs handshake) {
+	if r.hosts.primary() == nil {
+		offline := r.Config.OfflineStatus
+		rejectPlayer(conn, hs, offline.response(hs.ProtocolVersion), offline.KickMessage)
+		return
+	}
+	msg := r.Config.UnknownHostMessage
+	rejectPlayer(conn, hs, statusResponse(hs.ProtocolVersion, msg), msg)
 }
 
 // startBedrockServer starts the UDP listener for Bedrock Edition players (Geyser)
 func (r *Relay) startBedrockServer() {
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", r.Config.BedrockPort))
 	if err != nil {
-		r.Log(fmt.Sprintf("[Bedrock] Failed to resolve address: %v", err))
+		r.logf(LevelError, "Bedrock", nil, "Failed to resolve address: %v", err)
 		return
 	}
 
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
-		r.Log(fmt.Sprintf("[Bedrock] Listener failed: %v", err))
+		r.logf(LevelError, "Bedrock", nil, "Listener failed: %v", err)
 		return
 	}
 	defer conn.Close()
 
-	r.Log(fmt.Sprintf("[Bedrock] Listening on :%d (UDP)", r.Config.BedrockPort))
+	r.logf(LevelInfo, "Bedrock", nil, "Listening on :%d (UDP)", r.Config.BedrockPort)
 
-	// Track active Bedrock sessions
-	sessions := make(map[string]*bedrockSession)
-	sessionsMutex := sync.Mutex{}
+	r.bedrockPong.offlineGUID = rand.Int64()
+	go r.refreshBedrockPong()
+	go r.reapBedrockSessions()
 
 	buffer := make([]byte, 65535) // Max UDP packet size
+	var lastLimitLog time.Time
 
 	for {
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
-			r.Log(fmt.Sprintf("[Bedrock] Read error: %v", err))
+			r.logf(LevelWarn, "Bedrock", nil, "Read error: %v", err)
 			continue
 		}
 
@@ -213,18 +596,49 @@ func (r *Relay) startBedrockServer() {
 		data := make([]byte, n)
 		copy(data, buffer[:n])
 
-		sessionsMutex.Lock()
-		session, exists := sessions[key]
+		r.bedrockMu.Lock()
+		session, exists := r.bedrockSessions[key]
+		r.bedrockMu.Unlock()
+
+		// Refused addresses get no pong and no session
+		if !exists && !r.checkACL("Bedrock", remoteAddr) {
+			continue
+		}
+
+		// Server list pings are answered here instead of opening a stream
+		if isUnconnectedPing(data) && r.answerBedrockPing(conn, remoteAddr, data) {
+			continue
+		}
+
+		r.bedrockMu.Lock()
+		session, exists = r.bedrockSessions[key]
 		if !exists {
+			if limit := r.Config.BedrockMaxSessions; limit > 0 && len(r.bedrockSessions) >= limit {
+				r.bedrockMu.Unlock()
+				if time.Since(lastLimitLog) > 10*time.Second {
+					r.logf(LevelWarn, "Bedrock", nil, "Session limit (%d) reached, dropping packets from new players", limit)
+					lastLimitLog = time.Now()
+				}
+				continue
+			}
+
+			release := r.admit("Bedrock", remoteAddr)
+			if release == nil {
+				r.bedrockMu.Unlock()
+				continue
+			}
+			atomic.AddInt64(&r.bedrockAccepted, 1)
+
 			// New Bedrock player
-			session = r.createBedrockSession(conn, remoteAddr, sessions, &sessionsMutex)
+			session = r.createBedrockSession(conn, remoteAddr, release)
 			if session == nil {
-				sessionsMutex.Unlock()
+				release()
+				r.bedrockMu.Unlock()
 				continue
 			}
-			sessions[key] = session
+			r.bedrockSessions[key] = session
 		}
-		sessionsMutex.Unlock()
+		r.bedrockMu.Unlock()
 
 		// Forward packet to tunnel
 		session.sendToTunnel(data)
@@ -233,49 +647,113 @@ func (r *Relay) startBedrockServer() {
 
 type bedrockSession struct {
 	relay      *Relay
+	host       *hostSession
+	conn       *connection
 	udpConn    *net.UDPConn
 	remoteAddr *net.UDPAddr
 	stream     net.Conn
 	done       chan struct{}
+
+	release    func() // Returns the connection slot taken in admit
+	lastActive int64  // Unix nanoseconds of the player's last packet
+	reaped     int32  // Set when the idle reaper closed the session
+}
+
+// touch records a packet from the player
+func (s *bedrockSession) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// close ends the session. Close only half-closes a yamux stream, so this
+// also wakes the reader.
+func (s *bedrockSession) close() {
+	s.stream.Close()
+	s.stream.SetReadDeadline(time.Now())
+}
+
+// idle returns how long the session has been silent
+func (s *bedrockSession) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+}
+
+// BedrockSessions returns the number of open Bedrock sessions
+func (r *Relay) BedrockSessions() int {
+	r.bedrockMu.Lock()
+	defer r.bedrockMu.Unlock()
+	return len(r.bedrockSessions)
 }
 
-func (r *Relay) createBedrockSession(udpConn *net.UDPConn, remoteAddr *net.UDPAddr, sessions map[string]*bedrockSession, mutex *sync.Mutex) *bedrockSession {
-	r.tunnelMutex.Lock()
-	tunnelSession := r.tunnelSession
-	r.tunnelMutex.Unlock()
+// reapBedrockSessions closes sessions whose player went silent. UDP has no
+// close, so this is how abandoned or spoofed addresses are released.
+func (r *Relay) reapBedrockSessions() {
+	interval := r.Config.BedrockIdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var idle []*bedrockSession
+		r.bedrockMu.Lock()
+		for _, s := range r.bedrockSessions {
+			if s.idle() >= r.Config.BedrockIdleTimeout {
+				idle = append(idle, s)
+			}
+		}
+		r.bedrockMu.Unlock()
 
-	if tunnelSession == nil {
+		for _, s := range idle {
+			if atomic.CompareAndSwapInt32(&s.reaped, 0, 1) {
+				r.logf(LevelInfo, "Bedrock", nil, "Session %s idle for %s, closing", s.remoteAddr, s.idle().Round(time.Second))
+				s.close()
+			}
+		}
+	}
+}
+
+func (r *Relay) createBedrockSession(udpConn *net.UDPConn, remoteAddr *net.UDPAddr, release func()) *bedrockSession {
+	if r.hosts.primary() == nil {
 		return nil
 	}
 
-	r.Log(fmt.Sprintf("[Bedrock] Player connected: %s", remoteAddr.String()))
-	atomic.AddInt64(&r.ActivePlayers, 1)
+	r.logf(LevelInfo, "Bedrock", Fields{"remote_addr": remoteAddr.String()}, "Player connected: %s", remoteAddr.String())
 
-	stream, err := tunnelSession.Open()
+	host, stream, err := r.openStream("")
 	if err != nil {
-		r.Log(fmt.Sprintf("[Bedrock] Failed to open stream: %v", err))
-		atomic.AddInt64(&r.ActivePlayers, -1)
+		r.logf(LevelWarn, "Bedrock", nil, "Failed to open stream: %v", err)
 		return nil
 	}
 
 	// Send Player IP Header with UDP protocol marker
 	if _, err := stream.Write([]byte("udp:" + remoteAddr.String() + "\n")); err != nil {
-		r.Log(fmt.Sprintf("[Bedrock] Failed to send header: %v", err))
+		r.logf(LevelWarn, "Bedrock", nil, "Failed to send header: %v", err)
 		stream.Close()
-		atomic.AddInt64(&r.ActivePlayers, -1)
+		host.release()
 		return nil
 	}
 
+	conn := &connection{protocol: "udp", remoteAddr: remoteAddr, host: host, total: &r.bedrockTraffic}
+
 	session := &bedrockSession{
 		relay:      r,
+		host:       host,
+		conn:       conn,
 		udpConn:    udpConn,
 		remoteAddr: remoteAddr,
 		stream:     stream,
 		done:       make(chan struct{}),
+		release:    release,
 	}
+	session.touch()
+
+	// Bedrock traffic is encrypted, so a kick can only drop the session
+	conn.kick = func(string) { session.close() }
+	r.connections.add(conn)
+	r.emit(conn.event(EventPlayerConnected))
 
 	// Start goroutine to read from tunnel and send back to UDP client
-	go session.readFromTunnel(sessions, mutex)
+	go session.readFromTunnel()
 
 	return session
 }
@@ -288,18 +766,27 @@ func (s *bedrockSession) sendToTunnel(data []byte) {
 
 	s.stream.Write(lenBuf)
 	s.stream.Write(data)
-	atomic.AddInt64(&s.relay.GlobalBytes, int64(len(data)+2))
+	s.conn.count(true, len(data), 1)
+	s.touch()
 }
 
-func (s *bedrockSession) readFromTunnel(sessions map[string]*bedrockSession, mutex *sync.Mutex) {
+func (s *bedrockSession) readFromTunnel() {
 	defer func() {
 		s.stream.Close()
-		atomic.AddInt64(&s.relay.ActivePlayers, -1)
-		s.relay.Log(fmt.Sprintf("[Bedrock] Player disconnected: %s", s.remoteAddr.String()))
+		s.host.release()
+		s.release()
+		s.relay.connections.remove(s.conn)
+		if atomic.LoadInt32(&s.reaped) == 1 {
+			atomic.AddInt64(&s.relay.BedrockReaped, 1)
+		} else {
+			atomic.AddInt64(&s.relay.BedrockClosed, 1)
+		}
+		s.relay.logf(LevelInfo, "Bedrock", s.conn.disconnectFields(), "Player disconnected: %s", s.remoteAddr.String())
+		s.relay.emit(s.conn.event(EventPlayerDisconnected))
 
-		mutex.Lock()
-		delete(sessions, s.remoteAddr.String())
-		mutex.Unlock()
+		s.relay.bedrockMu.Lock()
+		delete(s.relay.bedrockSessions, s.remoteAddr.String())
+		s.relay.bedrockMu.Unlock()
 
 		close(s.done)
 	}()
@@ -324,67 +811,9 @@ func (s *bedrockSession) readFromTunnel(sessions map[string]*bedrockSession, mut
 			return
 		}
 
-		atomic.AddInt64(&s.relay.GlobalBytes, int64(pktLen+2))
+		s.conn.count(false, pktLen, 1)
 
 		// Send back to UDP client
 		s.udpConn.WriteToUDP(data, s.remoteAddr)
 	}
 }
-
-// CountingReader wraps an io.Reader and counts bytes read
-type CountingReader struct {
-	r       io.Reader
-	counter *int64
-}
-
-func (c *CountingReader) Read(p []byte) (n int, err error) {
-	n, err = c.r.Read(p)
-	if n > 0 {
-		atomic.AddInt64(c.counter, int64(n))
-	}
-	return
-}
-
-// LogBroadcaster handles multiple subscribers for logs
-type LogBroadcaster struct {
-	subscribers []chan string
-	mu          sync.Mutex
-}
-
-func NewLogBroadcaster() *LogBroadcaster {
-	return &LogBroadcaster{
-		subscribers: make([]chan string, 0),
-	}
-}
-
-func (b *LogBroadcaster) Subscribe() chan string {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	ch := make(chan string, 100)
-	b.subscribers = append(b.subscribers, ch)
-	return ch
-}
-
-func (b *LogBroadcaster) Unsubscribe(ch chan string) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	for i, sub := range b.subscribers {
-		if sub == ch {
-			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
-			close(ch)
-			break
-		}
-	}
-}
-
-func (b *LogBroadcaster) Broadcast(msg string) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	for _, ch := range b.subscribers {
-		select {
-		case ch <- msg:
-		default:
-			// Drop message if channel is full to prevent blocking
-		}
-	}
-}
@@ -0,0 +1,159 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// statusCacheMaxStale is how old a cached status may be and still be
+	// served when the host cannot answer
+	statusCacheMaxStale = 5 * time.Minute
+
+	// maxStatusCacheEntries bounds the cache, since catch-all hosts accept
+	// any server address a scraper makes up
+	maxStatusCacheEntries = 1024
+
+	// maxStatusResponseSize bounds a host's status response (favicons
+	// make it large)
+	maxStatusResponseSize = 1024 * 1024
+)
+
+type statusKey struct {
+	hostname string
+	protocol int32
+}
+
+type statusEntry struct {
+	response string
+	fetched  time.Time
+}
+
+// statusCache holds the last status response per server address and
+// protocol version
+type statusCache struct {
+	mu      sync.Mutex
+	entries map[statusKey]statusEntry
+}
+
+func (c *statusCache) get(key statusKey) (statusEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *statusCache) put(key statusKey, response string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[statusKey]statusEntry)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxStatusCacheEntries {
+		for k, e := range c.entries {
+			if time.Since(e.fetched) > statusCacheMaxStale {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxStatusCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = statusEntry{response: response, fetched: time.Now()}
+}
+
+// serveCachedStatus answers a server list ping from the cache, asking the
+// host only when the entry is older than the TTL. A stale entry covers
+// for a host that cannot answer.
+func (r *Relay) serveCachedStatus(playerConn net.Conn, hs handshake, peeked []byte) {
+	// Long enough to ask the host and then answer the player
+	playerConn.SetDeadline(time.Now().Add(2 * playerHandshakeTimeout))
+
+	key := statusKey{hostname: hs.ServerAddress, protocol: hs.ProtocolVersion}
+	entry, cached := r.statusCache.get(key)
+	if cached && time.Since(entry.fetched) < r.Config.StatusCacheTTL {
+		serveStatus(playerConn, entry.response)
+		return
+	}
+
+	host, response, err := r.fetchStatus(playerConn.RemoteAddr(), hs, peeked)
+	if err == nil {
+		r.statusCache.put(key, response)
+		// Like any player traffic, the exchange counts for the host that
+		// answered it. Answers from the cache count for no host, so they
+		// are left out of the Java total too.
+		counted := &countingConn{Conn: playerConn}
+		serveStatus(counted, response)
+		for _, t := range []*Traffic{&host.traffic, &r.javaTraffic} {
+			t.add(true, counted.in, 0)
+			t.add(false, counted.out, 0)
+		}
+		return
+	}
+
+	if cached && time.Since(entry.fetched) < statusCacheMaxStale {
+		serveStatus(playerConn, entry.response)
+		return
+	}
+	if !errors.Is(err, errNoHost) {
+		r.logf(LevelWarn, "Game", nil, "Status request for %q failed: %v", hs.ServerAddress, err)
+	}
+	r.rejectUnroutable(playerConn, hs)
+}
+
+// fetchStatus asks a host for its status response on behalf of a player
+// and returns the host that answered
+func (r *Relay) fetchStatus(playerAddr net.Addr, hs handshake, peeked []byte) (*hostSession, string, error) {
+	host, stream, err := r.openStream(hs.ServerAddress)
+	if err != nil {
+		return nil, "", err
+	}
+	defer host.release()
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(playerHandshakeTimeout))
+	request := append([]byte("tcp:"+playerAddr.String()+"\n"), peeked...)
+	if _, err := stream.Write(request); err != nil {
+		return nil, "", err
+	}
+	if err := writePacket(stream, 0x00, nil); err != nil {
+		return nil, "", err
+	}
+
+	id, payload, err := readPacket(stream, maxStatusResponseSize)
+	if err != nil {
+		return nil, "", err
+	}
+	if id != 0x00 {
+		return nil, "", fmt.Errorf("unexpected packet 0x%02x", id)
+	}
+	response, err := readString(bytes.NewReader(payload))
+	if err != nil {
+		return nil, "", err
+	}
+	return host, response, nil
+}
+
+// countingConn counts the bytes read from (in) and written to (out) a
+// connection
+type countingConn struct {
+	net.Conn
+	in, out int
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in += n
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out += n
+	return n, err
+}
@@ -0,0 +1,263 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tunnel/pkg/tunnel"
+)
+
+// Webhook body formats
+const (
+	WebhookFormatJSON    = "json"    // The event as /events sends it
+	WebhookFormatDiscord = "discord" // A Discord webhook message with one embed
+)
+
+// Webhook is a URL the relay POSTs events to
+type Webhook struct {
+	Name   string   `json:"name,omitempty"` // Shown in logs instead of the URL's host
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"` // Event types to send, every type when empty
+	Secret string   `json:"secret,omitempty"` // Signs bodies with HMAC-SHA256 in X-Relay-Signature
+	Format string   `json:"format,omitempty"` // WebhookFormatJSON (default) or WebhookFormatDiscord
+}
+
+// webhooksFile is the format of the webhooks file
+type webhooksFile struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+const (
+	webhookAttempts = 5
+	webhookTimeout  = 10 * time.Second
+	webhookMaxDelay = time.Minute
+
+	// Events waiting while a webhook retries; later ones are dropped
+	webhookQueue = 256
+)
+
+// webhookRetryDelay is the wait before the first retry. It doubles after
+// each failed attempt. Tests shorten it.
+var webhookRetryDelay = time.Second
+
+// LoadWebhooks reads a webhooks file. A missing file has no webhooks.
+func LoadWebhooks(path string) ([]Webhook, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file webhooksFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid webhooks file %s: %w", path, err)
+	}
+	for i, w := range file.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %d in %s has invalid url %q", i+1, path, w.URL)
+		}
+		for _, t := range w.Events {
+			if !slices.Contains(eventTypes, t) {
+				return nil, fmt.Errorf("webhook %d in %s has unknown event type %q", i+1, path, t)
+			}
+		}
+		switch w.Format {
+		case "", WebhookFormatJSON, WebhookFormatDiscord:
+		default:
+			return nil, fmt.Errorf("webhook %d in %s has format %q (want json or discord)", i+1, path, w.Format)
+		}
+	}
+	return file.Webhooks, nil
+}
+
+// label names the webhook in logs without its URL, which often embeds a
+// secret token
+func (w *Webhook) label() string {
+	if w.Name != "" {
+		return w.Name
+	}
+	if u, err := url.Parse(w.URL); err == nil {
+		return u.Host
+	}
+	return "webhook"
+}
+
+// startWebhooks subscribes every configured webhook to the relay's events
+func (r *Relay) startWebhooks() {
+	for i := range r.Config.Webhooks {
+		w := &r.Config.Webhooks[i]
+		events := "all"
+		if len(w.Events) > 0 {
+			events = strings.Join(w.Events, ", ")
+		}
+		r.logf(LevelInfo, "Webhook", nil, "Sending %s events to %s", events, w.label())
+		go r.runWebhook(w, r.events.subscribe(webhookQueue))
+	}
+}
+
+// runWebhook delivers events one at a time so the receiver sees them in
+// order
+func (r *Relay) runWebhook(w *Webhook, events chan Event) {
+	client := &http.Client{Timeout: webhookTimeout}
+	for e := range events {
+		if len(w.Events) > 0 && !slices.Contains(w.Events, e.Type) {
+			continue
+		}
+		var body []byte
+		if w.Format == WebhookFormatDiscord {
+			body, _ = json.Marshal(discordMessage(e))
+		} else {
+			body, _ = json.Marshal(e)
+		}
+		r.deliver(client, w, e.Type, body)
+	}
+}
+
+// deliver posts body, retrying network errors, 429 and 5xx responses with
+// exponential backoff
+func (r *Relay) deliver(client *http.Client, w *Webhook, eventType string, body []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retryAfter, retry, err := w.post(client, eventType, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			r.logf(LevelWarn, "Webhook", Fields{"webhook": w.label(), "event": eventType, "attempts": attempt},
+				"Failed to deliver %s to %s: %v", eventType, w.label(), err)
+			return
+		}
+		r.logf(LevelDebug, "Webhook", Fields{"webhook": w.label(), "event": eventType, "attempt": attempt},
+			"Delivering %s to %s failed, retrying: %v", eventType, w.label(), err)
+
+		wait := max(delay, min(retryAfter, webhookMaxDelay))
+		time.Sleep(wait)
+		delay = min(delay*2, webhookMaxDelay)
+	}
+}
+
+// post sends body once. It reports whether a failure is worth retrying
+// and how long the receiver asked to wait first.
+func (w *Webhook) post(client *http.Client, eventType string, body []byte) (retryAfter time.Duration, retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tunnel-relay/"+tunnel.Version)
+	req.Header.Set("X-Relay-Event", eventType)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Relay-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, true, fmt.Errorf("HTTP %s", resp.Status)
+	default:
+		return 0, false, fmt.Errorf("HTTP %s", resp.Status)
+	}
+}
+
+// Embed colors for Discord messages
+const (
+	discordGreen = 0x04B575
+	discordAmber = 0xFFAA00
+	discordRed   = 0xFF5555
+)
+
+type discordPayload struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Color       int    `json:"color"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// seconds renders an event's duration field to the second
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
+
+// discordMessage describes e as a Discord webhook message
+func discordMessage(e Event) discordPayload {
+	who := e.Player
+	if who == "" {
+		who = e.RemoteAddr
+	}
+	host := fmt.Sprintf("Host #%d", e.HostID)
+	if e.Identity != "" {
+		host += " (" + e.Identity + ")"
+	}
+
+	embed := discordEmbed{Timestamp: e.Time.Format(time.RFC3339)}
+	switch e.Type {
+	case EventPlayerConnected:
+		embed.Title, embed.Color = who+" joined", discordGreen
+		embed.Description = e.Edition
+		if e.Hostname != "" {
+			embed.Description += " · " + e.Hostname
+		}
+	case EventPlayerDisconnected:
+		embed.Title, embed.Color = who+" left", discordAmber
+		embed.Description = fmt.Sprintf("%s · played %s", e.Edition, seconds(e.DurationSeconds))
+	case EventHostConnected:
+		embed.Title, embed.Color = host+" connected", discordGreen
+		embed.Description = "Role: " + e.Role
+	case EventHostDisconnected:
+		embed.Title, embed.Color = host+" disconnected", discordAmber
+		embed.Description = "Role: " + e.Role
+		if e.TunnelConnected != nil && !*e.TunnelConnected {
+			embed.Color = discordRed
+			embed.Description += " · no host left, tunnel down"
+		}
+	case EventRateLimited, EventACLDenied:
+		embed.Title, embed.Color = "Rejected "+who, discordRed
+		embed.Description = e.Reason
+		if e.Suppressed > 0 {
+			embed.Description += fmt.Sprintf(" (%d more not reported)", e.Suppressed)
+		}
+	case EventTunnelDown:
+		embed.Title, embed.Color = "Tunnel down", discordRed
+		embed.Description = fmt.Sprintf("No host connected for %s", seconds(e.DownSeconds))
+	case EventTunnelRestored:
+		embed.Title, embed.Color = "Tunnel restored", discordGreen
+		embed.Description = fmt.Sprintf("Down for %s", seconds(e.DownSeconds))
+	default:
+		embed.Title, embed.Color = e.Type, discordAmber
+	}
+	return discordPayload{Username: "Tunnel Relay", Embeds: []discordEmbed{embed}}
+}
@@ -0,0 +1,273 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookRequest is one POST seen by a webhookReceiver
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// webhookReceiver is a test server that records every POST and answers
+// the nth one (counting from 1) with status(n)
+type webhookReceiver struct {
+	*httptest.Server
+	requests chan webhookRequest
+	count    atomic.Int64
+}
+
+func newWebhookReceiver(t *testing.T, status func(n int) int) *webhookReceiver {
+	t.Helper()
+	rec := &webhookReceiver{requests: make(chan webhookRequest, 100)}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		n := int(rec.count.Add(1))
+		rec.requests <- webhookRequest{header: req.Header, body: body, at: time.Now()}
+		w.WriteHeader(status(n))
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// next waits for the receiver's next request
+func (rec *webhookReceiver) next(t *testing.T) webhookRequest {
+	t.Helper()
+	select {
+	case r := <-rec.requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+		return webhookRequest{}
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Set once: webhook goroutines outlive the test that started them
+	webhookRetryDelay = 20 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// startWebhookRelay returns a relay posting events to w
+func startWebhookRelay(t *testing.T, w Webhook) *Relay {
+	t.Helper()
+	r := New(Config{Webhooks: []Webhook{w}})
+	r.startWebhooks()
+	return r
+}
+
+func accept(int) int { return http.StatusNoContent }
+
+func TestWebhookSignature(t *testing.T) {
+	rec := newWebhookReceiver(t, accept)
+	r := startWebhookRelay(t, Webhook{URL: rec.URL, Secret: "s3cret"})
+
+	r.emit(Event{Type: EventPlayerConnected, Player: "Steve"})
+	req := rec.next(t)
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(req.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.header.Get("X-Relay-Signature"); got != want {
+		t.Errorf("X-Relay-Signature = %q, want %q", got, want)
+	}
+	if got := req.header.Get("X-Relay-Event"); got != EventPlayerConnected {
+		t.Errorf("X-Relay-Event = %q, want %q", got, EventPlayerConnected)
+	}
+
+	var e Event
+	if err := json.Unmarshal(req.body, &e); err != nil {
+		t.Fatalf("body is not an event: %v", err)
+	}
+	if e.Type != EventPlayerConnected || e.Player != "Steve" {
+		t.Errorf("body = %s, want the emitted event", req.body)
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	rec := newWebhookReceiver(t, accept)
+	r := startWebhookRelay(t, Webhook{URL: rec.URL})
+
+	r.emit(Event{Type: EventPlayerConnected})
+	if got := rec.next(t).header.Get("X-Relay-Signature"); got != "" {
+		t.Errorf("X-Relay-Signature = %q, want none", got)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			// Fail twice, then accept
+			rec := newWebhookReceiver(t, func(n int) int {
+				if n <= 2 {
+					return status
+				}
+				return http.StatusOK
+			})
+			r := startWebhookRelay(t, Webhook{URL: rec.URL})
+
+			r.emit(Event{Type: EventHostConnected})
+			first, second, third := rec.next(t), rec.next(t), rec.next(t)
+
+			if gap := second.at.Sub(first.at); gap < webhookRetryDelay {
+				t.Errorf("first retry after %s, want at least %s", gap, webhookRetryDelay)
+			}
+			if gap := third.at.Sub(second.at); gap < 2*webhookRetryDelay {
+				t.Errorf("second retry after %s, want at least %s", gap, 2*webhookRetryDelay)
+			}
+			if string(third.body) != string(first.body) {
+				t.Errorf("retry sent %s, want %s", third.body, first.body)
+			}
+
+			select {
+			case <-rec.requests:
+				t.Error("delivered again after success")
+			case <-time.After(8 * webhookRetryDelay):
+			}
+		})
+	}
+}
+
+func TestWebhookGivesUpAfterAttempts(t *testing.T) {
+	rec := newWebhookReceiver(t, func(int) int { return http.StatusBadGateway })
+	r := startWebhookRelay(t, Webhook{URL: rec.URL})
+
+	r.emit(Event{Type: EventHostConnected})
+	for range webhookAttempts {
+		rec.next(t)
+	}
+	select {
+	case <-rec.requests:
+		t.Errorf("more than %d attempts", webhookAttempts)
+	case <-time.After(40 * webhookRetryDelay):
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	rec := newWebhookReceiver(t, func(int) int { return http.StatusNotFound })
+	r := startWebhookRelay(t, Webhook{URL: rec.URL})
+
+	// Events are delivered in order, so a retry of the first would come
+	// before the second
+	r.emit(Event{Type: EventHostConnected})
+	r.emit(Event{Type: EventHostDisconnected})
+
+	if got := rec.next(t).header.Get("X-Relay-Event"); got != EventHostConnected {
+		t.Fatalf("first request for %q, want %q", got, EventHostConnected)
+	}
+	if got := rec.next(t).header.Get("X-Relay-Event"); got != EventHostDisconnected {
+		t.Errorf("second request for %q, want %q instead of a retry", got, EventHostDisconnected)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	rec := newWebhookReceiver(t, accept)
+	r := startWebhookRelay(t, Webhook{URL: rec.URL, Events: []string{EventTunnelDown, EventTunnelRestored}})
+
+	r.emit(Event{Type: EventPlayerConnected})
+	r.emit(Event{Type: EventHostDisconnected})
+	r.emit(Event{Type: EventTunnelDown})
+	r.emit(Event{Type: EventPlayerDisconnected})
+	r.emit(Event{Type: EventTunnelRestored})
+
+	for _, want := range []string{EventTunnelDown, EventTunnelRestored} {
+		if got := rec.next(t).header.Get("X-Relay-Event"); got != want {
+			t.Errorf("delivered %q, want %q", got, want)
+		}
+	}
+	select {
+	case req := <-rec.requests:
+		t.Errorf("delivered %q, which the webhook did not ask for", req.header.Get("X-Relay-Event"))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookDiscordFormat(t *testing.T) {
+	rec := newWebhookReceiver(t, accept)
+	r := startWebhookRelay(t, Webhook{URL: rec.URL, Format: WebhookFormatDiscord})
+
+	r.emit(Event{Type: EventPlayerConnected, Edition: "java", Player: "Steve", Hostname: "mc.example.com"})
+	req := rec.next(t)
+
+	// Discord rejects messages without content or embeds
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	for key := range payload {
+		switch key {
+		case "username", "content", "embeds":
+		default:
+			t.Errorf("unexpected field %q in %s", key, req.body)
+		}
+	}
+	if _, ok := payload["embeds"]; !ok {
+		if _, ok := payload["content"]; !ok {
+			t.Fatalf("no content or embeds in %s", req.body)
+		}
+	}
+
+	var message struct {
+		Embeds []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Color       int    `json:"color"`
+			Timestamp   string `json:"timestamp"`
+		} `json:"embeds"`
+	}
+	json.Unmarshal(req.body, &message)
+	if len(message.Embeds) != 1 {
+		t.Fatalf("got %d embeds, want 1", len(message.Embeds))
+	}
+	embed := message.Embeds[0]
+	if embed.Title != "Steve joined" {
+		t.Errorf("title = %q, want %q", embed.Title, "Steve joined")
+	}
+	if embed.Description != "java · mc.example.com" {
+		t.Errorf("description = %q, want %q", embed.Description, "java · mc.example.com")
+	}
+	if embed.Color != discordGreen {
+		t.Errorf("color = %#x, want %#x", embed.Color, discordGreen)
+	}
+	if _, err := time.Parse(time.RFC3339, embed.Timestamp); err != nil {
+		t.Errorf("timestamp %q is not RFC 3339: %v", embed.Timestamp, err)
+	}
+}
+
+func TestWebhookSlowReceiverDoesNotBlockPublish(t *testing.T) {
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	// Cleanups run last first: release the handlers before closing
+	t.Cleanup(receiver.Close)
+	t.Cleanup(func() { close(release) })
+
+	r := startWebhookRelay(t, Webhook{URL: receiver.URL})
+
+	done := make(chan struct{})
+	go func() {
+		// Enough to fill the webhook's queue while the first delivery hangs
+		for range 2 * webhookQueue {
+			r.emit(Event{Type: EventPlayerConnected})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publishing blocked on a slow webhook")
+	}
+}
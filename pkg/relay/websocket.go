@@ -0,0 +1,239 @@
+package relay
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server side of RFC 6455, just enough to push messages to a client:
+// no extensions, no fragmented sends, and client messages are discarded.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+const (
+	// websocketPingInterval keeps idle sockets alive through proxies and
+	// finds clients that vanished without closing
+	websocketPingInterval = 30 * time.Second
+
+	websocketWriteTimeout = 10 * time.Second
+
+	// Larger client frames close the socket; nothing the client sends is used
+	websocketMaxFrame = 64 * 1024
+)
+
+// isWebSocketUpgrade reports whether req asks to switch to a WebSocket
+func isWebSocketUpgrade(req *http.Request) bool {
+	return headerHasToken(req.Header, "Connection", "upgrade") && headerHasToken(req.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, ignoring case
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is an upgraded WebSocket connection. Writes may come from the
+// sender and the read loop at once.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+// originAllowed reports whether a browser page may open a WebSocket.
+// Browsers let any page connect to any WebSocket, so without this check a
+// page on the relay's machine could read a loopback API that has no
+// tokens. Requests without an Origin do not come from a browser.
+func originAllowed(req *http.Request, allowed []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// upgradeWebSocket completes the opening handshake, answering with an
+// error response if the request is not a valid one or comes from a page
+// on another origin than the API or allowedOrigins
+func upgradeWebSocket(w http.ResponseWriter, req *http.Request, allowedOrigins []string) (*wsConn, error) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "WebSocket handshake must use GET")
+		return nil, errors.New("websocket: method not GET")
+	}
+	if !originAllowed(req, allowedOrigins) {
+		writeError(w, http.StatusForbidden, "origin not allowed")
+		return nil, errors.New("websocket: origin not allowed")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, "unsupported WebSocket version")
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing Sec-WebSocket-Key")
+		return nil, errors.New("websocket: missing key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "WebSocket not supported on this connection")
+		return nil, errors.New("websocket: connection cannot be hijacked")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's timeouts no longer apply to a hijacked connection
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// writeFrame sends one unmasked, unfragmented frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// closeWith sends a close frame with a status code
+func (c *wsConn) closeWith(code uint16) {
+	c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// readLoop reads client frames until the client closes the socket or the
+// connection fails, answering pings and discarding everything else
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errFrameTooLarge) {
+				c.closeWith(1009) // Message too big
+			} else if errors.Is(err, errFrameUnmasked) {
+				c.closeWith(1002) // Protocol error
+			}
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			// Echo the status code, as the closing handshake requires
+			if len(payload) >= 2 {
+				c.writeFrame(wsOpClose, payload[:2])
+			} else {
+				c.writeFrame(wsOpClose, nil)
+			}
+			return
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+		}
+	}
+}
+
+var (
+	errFrameTooLarge = errors.New("websocket: frame too large")
+	errFrameUnmasked = errors.New("websocket: client frame not masked")
+)
+
+// readFrame reads one client frame and unmasks its payload
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errFrameUnmasked
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > websocketMaxFrame {
+		return 0, nil, errFrameTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,86 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		origin  string
+		allowed []string
+		want    bool
+	}{
+		{"no origin", "127.0.0.1:6060", "", nil, true},
+		{"same origin", "127.0.0.1:6060", "http://127.0.0.1:6060", nil, true},
+		{"same origin, other case", "localhost:6060", "http://LOCALHOST:6060", nil, true},
+		{"other page", "127.0.0.1:6060", "https://evil.example", nil, false},
+		{"other port", "127.0.0.1:6060", "http://127.0.0.1:8000", nil, false},
+		{"allowed", "127.0.0.1:6060", "https://dash.example.com", []string{"https://dash.example.com/"}, true},
+		{"not in allowlist", "127.0.0.1:6060", "https://evil.example", []string{"https://dash.example.com"}, false},
+		{"opaque origin", "127.0.0.1:6060", "null", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/events", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := originAllowed(req, tt.allowed); got != tt.want {
+				t.Errorf("originAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketUpgradeRejectsOtherOrigins(t *testing.T) {
+	r := New(Config{})
+	srv := httptest.NewServer(r.requireToken(http.HandlerFunc(r.handleEvents)))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header string
+		want   string
+	}{
+		{"header", http.MethodGet, "/events", "Bearer abc", "abc"},
+		{"header wins", http.MethodGet, "/events?access_token=query", "Bearer abc", "abc"},
+		{"query", http.MethodGet, "/events?access_token=abc", "", "abc"},
+		{"query ignored for writes", http.MethodDelete, "/connections/1?access_token=abc", "", ""},
+		{"other scheme", http.MethodGet, "/status", "Basic abc", ""},
+		{"none", http.MethodGet, "/status", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if got := requestToken(req); got != tt.want {
+				t.Errorf("requestToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
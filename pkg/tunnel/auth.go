@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Control channel authentication.
+//
+// Before the yamux session is set up the relay sends a random challenge and
+// the host answers with HMAC-SHA256(token, challenge). The relay replies with
+// a single status byte, so the token itself never crosses the wire.
+
+const (
+	ChallengeSize    = 32
+	HandshakeTimeout = 10 * time.Second
+
+	authOK     byte = 0x00
+	authFailed byte = 0x01
+)
+
+var ErrAuthFailed = errors.New("authentication failed")
+
+// ServerAuth challenges the host on conn and verifies its response
+func ServerAuth(conn net.Conn, token string) error {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	if _, err := conn.Write(challenge); err != nil {
+		return fmt.Errorf("failed to send challenge: %w", err)
+	}
+
+	response := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if !hmac.Equal(response, sign(token, challenge)) {
+		conn.Write([]byte{authFailed})
+		return ErrAuthFailed
+	}
+
+	if _, err := conn.Write([]byte{authOK}); err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+	return nil
+}
+
+// ClientAuth answers the relay's challenge on conn
+func ClientAuth(conn net.Conn, token string) error {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	challenge := make([]byte, ChallengeSize)
+	if _, err := io.ReadFull(conn, challenge); err != nil {
+		return fmt.Errorf("failed to read challenge: %w", err)
+	}
+	if _, err := conn.Write(sign(token, challenge)); err != nil {
+		return fmt.Errorf("failed to send response: %w", err)
+	}
+
+	result := make([]byte, 1)
+	if _, err := io.ReadFull(conn, result); err != nil {
+		return fmt.Errorf("failed to read result: %w", err)
+	}
+	if result[0] != authOK {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func sign(token string, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// LoadToken returns token, or the contents of tokenFile if token is empty
+func LoadToken(token, tokenFile string) (string, error) {
+	if token != "" && tokenFile != "" {
+		return "", errors.New("--token and --token-file are mutually exclusive")
+	}
+	if tokenFile == "" {
+		return token, nil
+	}
+
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token = strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", tokenFile)
+	}
+	return token, nil
+}
@@ -0,0 +1,179 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Control handshake.
+//
+// After authentication the host sends a Hello frame and the relay answers
+// with a HelloReply before either side starts yamux. Frames are a 2-byte
+// big-endian length followed by a JSON document.
+
+// ProtocolVersion is the control protocol spoken by this build
+const ProtocolVersion = 1
+
+// Version is the release version, set at build time with
+// -ldflags "-X tunnel/pkg/tunnel.Version=v1.2.3"
+var Version = "dev"
+
+// Feature flags negotiated in the hello exchange
+const (
+	// Streams start with a "tcp:<addr>\n" or "udp:<addr>\n" header
+	FeatureStreamHeader = "stream-header"
+
+	// Streams may start with "ping:\n" followed by one length-prefixed
+	// RakNet unconnected ping, answered with the local Bedrock server's pong
+	FeatureBedrockPing = "bedrock-ping"
+
+	// Java stream headers may carry the player's name and UUID after the
+	// address (see StreamHeader)
+	FeaturePlayerName = "player-name"
+)
+
+// Features lists every feature this build understands
+var Features = []string{
+	FeatureStreamHeader,
+	FeatureBedrockPing,
+	FeaturePlayerName,
+}
+
+var (
+	ErrIncompatible = errors.New("incompatible protocol")
+	ErrRejected     = errors.New("rejected by relay")
+)
+
+const maxFrameSize = 64 * 1024
+
+// Hello is sent by the host right after authentication
+type Hello struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	ClientVersion   string   `json:"client_version"`
+	Features        []string `json:"features"`
+	GamePort        int      `json:"game_port,omitempty"`    // Public Java port the host expects
+	BedrockPort     int      `json:"bedrock_port,omitempty"` // Public Bedrock port the host expects
+	Priority        int      `json:"priority,omitempty"`     // Standby rank, higher is preferred
+	Weight          int      `json:"weight,omitempty"`       // Share of players when the relay balances load
+	Hostnames       []string `json:"hostnames,omitempty"`    // Server addresses routed to this host, empty for all
+}
+
+// HelloReply is the relay's answer to Hello
+type HelloReply struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	ServerVersion   string   `json:"server_version"`
+	Features        []string `json:"features,omitempty"` // Accepted subset of Hello.Features
+	GamePort        int      `json:"game_port"`
+	BedrockPort     int      `json:"bedrock_port,omitempty"`
+	UDPIdleTimeout  int      `json:"udp_idle_timeout,omitempty"` // Seconds the relay keeps a silent Bedrock session
+	Error           string   `json:"error,omitempty"`            // Set when the host is rejected
+	Retry           bool     `json:"retry,omitempty"`            // The rejection is temporary
+}
+
+// WriteFrame writes v as a length-prefixed JSON frame
+func WriteFrame(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxFrameSize-1 {
+		return fmt.Errorf("frame too large: %d bytes", len(data))
+	}
+
+	buf := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadFrame reads a length-prefixed JSON frame into v
+func ReadFrame(r io.Reader, v any) error {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ClientHello sends hello and waits for the relay's reply. A reply carrying
+// an error is returned together with ErrIncompatible.
+func ClientHello(conn net.Conn, hello Hello) (HelloReply, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var reply HelloReply
+	if err := WriteFrame(conn, hello); err != nil {
+		return reply, fmt.Errorf("failed to send hello: %w", err)
+	}
+	if err := ReadFrame(conn, &reply); err != nil {
+		return reply, fmt.Errorf("failed to read hello reply: %w", err)
+	}
+	if reply.Error != "" {
+		if reply.Retry {
+			return reply, fmt.Errorf("%w: %s", ErrRejected, reply.Error)
+		}
+		return reply, fmt.Errorf("%w: %s", ErrIncompatible, reply.Error)
+	}
+	return reply, nil
+}
+
+// ServerHello reads the host's hello and answers with the reply built by
+// accept. If the reply carries an error it is still sent to the host.
+func ServerHello(conn net.Conn, accept func(Hello) HelloReply) (Hello, HelloReply, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var hello Hello
+	if err := ReadFrame(conn, &hello); err != nil {
+		return hello, HelloReply{}, fmt.Errorf("failed to read hello: %w", err)
+	}
+
+	reply := accept(hello)
+	reply.ProtocolVersion = ProtocolVersion
+	reply.ServerVersion = Version
+	if err := WriteFrame(conn, reply); err != nil {
+		return hello, reply, fmt.Errorf("failed to send hello reply: %w", err)
+	}
+	if reply.Error != "" {
+		if reply.Retry {
+			return hello, reply, fmt.Errorf("%w: %s", ErrRejected, reply.Error)
+		}
+		return hello, reply, fmt.Errorf("%w: %s", ErrIncompatible, reply.Error)
+	}
+	return hello, reply, nil
+}
+
+// Negotiate returns the features in offered that this build supports
+func Negotiate(offered []string) []string {
+	var accepted []string
+	for _, f := range offered {
+		for _, s := range Features {
+			if f == s {
+				accepted = append(accepted, f)
+				break
+			}
+		}
+	}
+	return accepted
+}
+
+// HasFeature reports whether features contains f
+func HasFeature(features []string, f string) bool {
+	for _, x := range features {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
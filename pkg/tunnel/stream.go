@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Stream headers.
+//
+// Every player stream starts with one line naming the protocol and the
+// player's address, "tcp:<addr>\n" for Java or "udp:<addr>\n" for Bedrock.
+// Hosts that negotiated FeaturePlayerName may also receive space-separated
+// key=value fields after the address, with values query-escaped:
+//
+//	tcp:203.0.113.5:51234 name=Steve uuid=069a79f4-44e9-4726-a5be-fca90e38aaf5
+
+// StreamHeader describes the player behind a stream
+type StreamHeader struct {
+	Protocol string // "tcp" or "udp"
+	Addr     string // Player address as host:port
+	Name     string // Java username from Login Start, "" if unknown
+	UUID     string // Java player UUID from Login Start, "" if not sent
+}
+
+// Format renders the header line, including the player fields only when
+// withPlayer is set (the host negotiated FeaturePlayerName)
+func (h StreamHeader) Format(withPlayer bool) string {
+	line := h.Protocol + ":" + h.Addr
+	if withPlayer {
+		if h.Name != "" {
+			line += " name=" + url.QueryEscape(h.Name)
+		}
+		if h.UUID != "" {
+			line += " uuid=" + url.QueryEscape(h.UUID)
+		}
+	}
+	return line + "\n"
+}
+
+// ParseStreamHeader parses a header line without its newline. Lines
+// without a protocol prefix are TCP addresses from old relays, and
+// unknown fields are ignored.
+func ParseStreamHeader(line string) StreamHeader {
+	var h StreamHeader
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return h
+	}
+
+	h.Protocol, h.Addr = "tcp", fields[0]
+	if proto, addr, ok := strings.Cut(fields[0], ":"); ok && (proto == "tcp" || proto == "udp") {
+		h.Protocol, h.Addr = proto, addr
+	}
+
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		value, err := url.QueryUnescape(value)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "name":
+			h.Name = value
+		case "uuid":
+			h.UUID = value
+		}
+	}
+	return h
+}
@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultServerName is the name placed in self-signed relay certificates
+const DefaultServerName = "tunnel-relay"
+
+// GenerateSelfSigned writes a new self-signed certificate and its key
+func GenerateSelfSigned(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: DefaultServerName},
+		DNSNames:              []string{DefaultServerName, "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return WriteKeyPair(certFile, keyFile, der, key)
+}
+
+// WriteKeyPair writes a DER certificate and its key as PEM files
+func WriteKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPem, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	return nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER certificate
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = hex.EncodeToString([]byte{b})
+	}
+	return strings.Join(parts, ":")
+}
+
+// FileFingerprint returns the fingerprint of the first certificate in a PEM file
+func FileFingerprint(certFile string) (string, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate found in %s", certFile)
+	}
+	return Fingerprint(block.Bytes), nil
+}
+
+// normalizeFingerprint accepts "AB:CD..", "abcd.." and "sha256:abcd.." forms
+func normalizeFingerprint(fp string) string {
+	fp = strings.ToLower(strings.TrimSpace(fp))
+	fp = strings.TrimPrefix(fp, "sha256:")
+	return strings.ReplaceAll(fp, ":", "")
+}
+
+// ClientTLSConfig builds the host's TLS config for the control connection.
+//
+// With a fingerprint the relay certificate must match it exactly. With a CA
+// file the chain must verify against that CA only; the hostname is checked
+// only when serverName is set. Without either the system roots are used.
+func ClientTLSConfig(caFile, fingerprint, serverName string) (*tls.Config, error) {
+	if caFile == "" && fingerprint == "" {
+		return &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}, nil
+	}
+
+	var roots *x509.CertPool
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	pinned := normalizeFingerprint(fingerprint)
+
+	return &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+		// Verification is done by VerifyConnection against the pin/CA instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("relay presented no certificate")
+			}
+			leaf := cs.PeerCertificates[0]
+
+			if pinned != "" && normalizeFingerprint(Fingerprint(leaf.Raw)) != pinned {
+				return fmt.Errorf("relay certificate fingerprint mismatch: got %s", Fingerprint(leaf.Raw))
+			}
+
+			if roots != nil {
+				intermediates := x509.NewCertPool()
+				for _, c := range cs.PeerCertificates[1:] {
+					intermediates.AddCert(c)
+				}
+				_, err := leaf.Verify(x509.VerifyOptions{
+					Roots:         roots,
+					Intermediates: intermediates,
+					DNSName:       serverName,
+				})
+				if err != nil {
+					return fmt.Errorf("relay certificate not trusted: %w", err)
+				}
+			}
+			return nil
+		},
+	}, nil
+}